@@ -10,5 +10,6 @@ import (
 
 func main() {
 	http.HandleFunc("/invoke", executor.InvokeHandler)
+	http.HandleFunc("/health", executor.HealthHandler)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", executor.DEFAULT_EXECUTOR_PORT), nil))
 }
@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/node"
@@ -27,6 +29,18 @@ import (
 func startAPIServer(e *echo.Echo) {
 	e.Use(middleware.Recover())
 
+	// callers are identified by the address they connect from, unless
+	// behind a trusted proxy
+	if proxy := config.GetString(config.API_TRUSTED_PROXY, ""); proxy != "" {
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxy range %s: %v\n", proxy, err)
+		}
+		e.IPExtractor = echo.ExtractIPFromXFFHeader(echo.TrustIPRange(ipRange))
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
 	// Routes
 	e.POST("/invoke/:fun", api.InvokeFunction)
 	e.POST("/prewarm", api.PrewarmFunction)
@@ -35,6 +49,14 @@ func startAPIServer(e *echo.Echo) {
 	e.GET("/function", api.GetFunctions)
 	e.GET("/poll/:reqId", api.PollAsyncResult)
 	e.GET("/status", api.GetServerStatus)
+	e.GET("/policy", api.GetPolicyState)
+	e.GET("/decisions", api.GetDecisions)
+	e.GET("/autoscaler", api.GetAutoscalerState)
+	e.POST("/drain", api.DrainNode)
+
+	if err := api.InitRateLimiting(); err != nil {
+		log.Fatalf("Invalid rate limits: %v\n", err)
+	}
 
 	// Start server
 	portNumber := config.GetInt(config.API_PORT, 1323)
@@ -65,34 +87,67 @@ func cacheSetup() {
 	cache.GetCacheInstance()
 }
 
+// registerTerminationHandler terminates the node on SIGINT, or drains it
+// first on SIGTERM or upon request through the API.
 func registerTerminationHandler(r *registration.Registry, e *echo.Echo) {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
+		drain := false
 		select {
 		case sig := <-c:
 			fmt.Printf("Got %s signal. Terminating...\n", sig)
-			node.ShutdownAllContainers()
+			drain = sig == syscall.SIGTERM
+		case <-api.DrainRequests():
+			fmt.Println("Draining requested. Terminating...")
+			drain = true
+		}
+
+		// deregister from etcd; server should be unreachable
+		err := r.Deregister()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-			// deregister from etcd; server should be unreachable
-			err := r.Deregister()
-			if err != nil {
-				log.Fatal(err)
-			}
+		if drain {
+			drainNode(c)
+		}
+
+		node.ShutdownAllContainers()
+
+		//stop container janitor
+		node.StopJanitor()
+		node.StopAutoscaler()
 
-			//stop container janitor
-			node.StopJanitor()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			e.Logger.Fatal(err)
+		}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			if err := e.Shutdown(ctx); err != nil {
-				e.Logger.Fatal(err)
-			}
+		os.Exit(0)
+	}()
+}
 
-			os.Exit(0)
+// drainNode waits for the requests in flight (up to the configured timeout),
+// while new ones are offloaded or refused. Another signal on c interrupts
+// draining.
+func drainNode(c <-chan os.Signal) {
+	timeout := time.Duration(config.GetInt(config.DRAIN_TIMEOUT, 60)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
+
+	if scheduling.Drain(ctx) {
+		log.Println("Drained: no request in flight")
+	}
 }
 
 func main() {
@@ -126,6 +181,7 @@ func main() {
 		log.Fatal(err)
 	}
 	node.NodeIdentifier = myKey
+	node.NodeAddress = url
 
 	go metrics.Init()
 
@@ -134,6 +190,8 @@ func main() {
 	// Register a signal handler to cleanup things on termination
 	registerTerminationHandler(registry, e)
 
+	// containers left by a previous run are adopted before serving requests
+	scheduling.Init()
 	schedulingPolicy := createSchedulingPolicy()
 	go scheduling.Run(schedulingPolicy)
 
@@ -151,15 +209,5 @@ func main() {
 func createSchedulingPolicy() scheduling.Policy {
 	policyConf := config.GetString(config.SCHEDULING_POLICY, "default")
 	log.Printf("Configured policy: %s\n", policyConf)
-	if policyConf == "cloudonly" {
-		return &scheduling.CloudOnlyPolicy{}
-	} else if policyConf == "edgecloud" {
-		return &scheduling.CloudEdgePolicy{}
-	} else if policyConf == "edgeonly" {
-		return &scheduling.EdgePolicy{}
-	} else if policyConf == "custom1" {
-		return &scheduling.Custom1Policy{}
-	} else {
-		return &scheduling.DefaultLocalPolicy{}
-	}
+	return scheduling.NewPolicy(policyConf)
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+)
+
+func usage() {
+	fmt.Printf("Usage: %s <model.json> <trace.jsonl> [config file]\n", os.Args[0])
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+	configFileName := ""
+	if len(os.Args) > 3 {
+		configFileName = os.Args[3]
+	}
+	config.ReadConfiguration(configFileName)
+
+	model := &scheduling.SimulationModel{}
+	modelFile, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = json.Unmarshal(modelFile, model); err != nil {
+		log.Fatalf("Invalid model: %v", err)
+	}
+
+	traceFile, err := os.Open(os.Args[2])
+	if err != nil {
+		log.Fatal(err)
+	}
+	trace, err := scheduling.ReadTrace(traceFile)
+	_ = traceFile.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	policyConf := config.GetString(config.SCHEDULING_POLICY, "default")
+	fmt.Printf("Simulating policy: %s (%d requests)\n", policyConf, len(trace))
+
+	// the scheduler logs every single decision
+	if !config.GetBool(config.SIMULATION_VERBOSE, false) {
+		log.SetOutput(io.Discard)
+	}
+	results, err := scheduling.Simulate(scheduling.NewPolicy(policyConf), model, trace)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	printResults(results)
+}
+
+func printResults(results *scheduling.SimulationResults) {
+	fmt.Printf("Simulated time: %.3f s\n", results.SimulatedTime)
+	if results.Unserved > 0 {
+		fmt.Printf("Requests never served: %d\n", results.Unserved)
+	}
+
+	classes := make([]string, 0, len(results.Classes))
+	for class := range results.Classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	fmt.Printf("%-14s %8s %9s %7s %9s %9s %7s %9s %9s %9s\n", "Class", "Requests", "Completed",
+		"Dropped", "DropRate", "Offloaded", "Cold", "Misses", "MeanRespT", "P95RespT")
+	for _, class := range classes {
+		s := results.Classes[class]
+		dropRate := 0.0
+		if s.Requests > 0 {
+			dropRate = float64(s.Dropped) / float64(s.Requests)
+		}
+		fmt.Printf("%-14s %8d %9d %7d %9.3f %9d %7d %9d %9.3f %9.3f\n", class, s.Requests, s.Completed,
+			s.Dropped, dropRate, s.Offloaded, s.ColdStarts, s.DeadlineMisses, s.MeanRespTime, s.P95RespTime)
+	}
+}
@@ -2,16 +2,20 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/clock"
 	"github.com/grussorusso/serverledge/internal/config"
 	"github.com/grussorusso/serverledge/internal/container"
 	"github.com/grussorusso/serverledge/internal/function"
@@ -23,6 +27,13 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// statusClientClosedRequest is returned when the client gives up on a request
+// before it is served (non-standard status code)
+const statusClientClosedRequest = 499
+
+// drainRequests is notified when the node must be drained (see DrainNode)
+var drainRequests = make(chan struct{}, 1)
+
 var requestsPool = sync.Pool{
 	New: func() any {
 		return new(function.Request)
@@ -54,38 +65,127 @@ func InvokeFunction(c echo.Context) error {
 		return fmt.Errorf("could not parse request: %v", err)
 	}
 
+	envelope := invocationRequest.Envelope
+	if envelope != nil {
+		if status, msg, ok := checkEnvelope(envelope, c.RealIP()); !ok {
+			log.Printf("Refusing offloaded request for '%s' from %s: %s\n", funcName, c.RealIP(), msg)
+			return c.String(status, msg)
+		}
+	}
+
+	if ok, wait := admitInvocation(c, funcName, envelope != nil); !ok {
+		return rejectInvocation(c, wait)
+	}
+
 	r := requestsPool.Get().(*function.Request)
-	defer requestsPool.Put(r)
+	// the request goes back to the pool, unless still in use by the scheduler
+	recycle := true
+	defer func() {
+		if recycle {
+			requestsPool.Put(r)
+		}
+	}()
 	r.Fun = fun
 	r.Params = invocationRequest.Params
-	r.Arrival = time.Now()
+	r.Arrival = clock.Now()
 	r.Class = function.ServiceClass(invocationRequest.QoSClass)
 	r.MaxRespT = invocationRequest.QoSMaxRespT
 	r.CanDoOffloading = invocationRequest.CanDoOffloading
 	r.Async = invocationRequest.Async
 	r.ReturnOutput = invocationRequest.ReturnOutput
-	r.ReqId = fmt.Sprintf("%s-%s%d", fun, node.NodeIdentifier[len(node.NodeIdentifier)-5:], r.Arrival.Nanosecond())
-	// init fields if possibly not overwritten later
-	r.ExecReport.SchedAction = ""
-	r.ExecReport.OffloadLatency = 0.0
+	maxHops := config.GetInt(config.SCHEDULER_OFFLOAD_MAX_HOPS, 2)
+	if envelope != nil {
+		applyEnvelope(r, envelope, maxHops)
+	} else {
+		r.ReqId = fmt.Sprintf("%s-%s%d", fun, node.NodeIdentifier[len(node.NodeIdentifier)-5:], r.Arrival.Nanosecond())
+		r.Offloading = function.OffloadingInfo{MaxHops: maxHops}
+	}
+	// reset the report, as the request object may be recycled from the pool
+	r.ExecReport = function.ExecutionReport{}
 
 	if r.Async {
-		go scheduling.SubmitAsyncRequest(r)
+		recycle = false
+		go func() {
+			// the client does not wait for async requests
+			ctx, cancel := requestContext(context.Background(), r)
+			defer cancel()
+			scheduling.SubmitAsyncRequest(ctx, r)
+		}()
 		return c.JSON(http.StatusOK, function.AsyncResponse{ReqId: r.ReqId})
 	}
 
-	err = scheduling.SubmitRequest(r)
+	ctx, cancel := requestContext(c.Request().Context(), r)
+	defer cancel()
+	err = scheduling.SubmitRequest(ctx, r)
 
 	if errors.Is(err, node.OutOfResourcesErr) {
-		return c.String(http.StatusTooManyRequests, "")
+		return invocationFailed(c, http.StatusTooManyRequests, "", err)
+	} else if errors.Is(err, scheduling.DeadlineExceededErr) {
+		recycle = false
+		return invocationFailed(c, http.StatusGatewayTimeout, "Deadline exceeded", err)
+	} else if errors.Is(err, scheduling.ExecutionTimeoutErr) {
+		return c.String(http.StatusGatewayTimeout, "Execution timed out")
+	} else if errors.Is(err, scheduling.NodeDrainingErr) {
+		return c.String(http.StatusServiceUnavailable, "Node is draining")
+	} else if errors.Is(err, context.Canceled) {
+		recycle = false
+		log.Printf("[%s] Request abandoned by the client\n", r)
+		return c.NoContent(statusClientClosedRequest)
 	} else if err != nil {
 		log.Printf("Invocation failed: %v\n", err)
-		return c.String(http.StatusInternalServerError, "")
+		return invocationFailed(c, http.StatusInternalServerError, "", err)
 	} else {
 		return c.JSON(http.StatusOK, function.Response{Success: true, ExecutionReport: r.ExecReport})
 	}
 }
 
+// invocationFailed replies to a failed invocation with the given status. If
+// the request could not be offloaded, the nodes tried are returned as well.
+func invocationFailed(c echo.Context, status int, msg string, err error) error {
+	var offloadErr *scheduling.OffloadErr
+	if errors.As(err, &offloadErr) {
+		report := function.ExecutionReport{OffloadAttempts: offloadErr.Attempts}
+		return c.JSON(status, function.Response{Success: false, ExecutionReport: report})
+	}
+	return c.String(status, msg)
+}
+
+// isPeer checks whether an IP address is the one of another node
+var isPeer = registration.IsPeer
+
+// checkEnvelope validates the envelope of an offloaded request, returning the
+// status and the message to reply with if it is refused.
+func checkEnvelope(envelope *client.OffloadEnvelope, callerIP string) (int, string, bool) {
+	// only other nodes offload requests
+	if !isPeer(callerIP) {
+		return http.StatusForbidden, "Envelope only accepted from registered nodes", false
+	}
+	if envelope.ReqId == "" {
+		return http.StatusBadRequest, "Envelope without ReqId", false
+	}
+	return http.StatusOK, "", true
+}
+
+// applyEnvelope sets the metadata of a request offloaded by another node,
+// which cannot allow more hops than this one.
+func applyEnvelope(r *function.Request, envelope *client.OffloadEnvelope, maxHops int) {
+	r.ReqId = envelope.ReqId
+	r.MaxRespT = envelope.RemainingRespT
+	if envelope.MaxHops < maxHops {
+		maxHops = envelope.MaxHops
+	}
+	r.Offloading = function.OffloadingInfo{Hops: envelope.Hops, MaxHops: maxHops, Visited: envelope.Visited}
+}
+
+// requestContext returns the context of an invocation, which is done when the
+// parent is done or when the request deadline (if any) expires.
+func requestContext(parent context.Context, r *function.Request) (context.Context, context.CancelFunc) {
+	if r.MaxRespT > 0.0 {
+		return context.WithDeadline(parent, r.Arrival.Add(time.Duration(r.MaxRespT*float64(time.Second))))
+	}
+	return context.WithCancel(parent)
+}
+
 // PollAsyncResult checks for the result of an asynchronous invocation.
 func PollAsyncResult(c echo.Context) error {
 	reqId := c.Param("reqId")
@@ -146,6 +246,7 @@ func CreateFunction(c echo.Context) error {
 		log.Printf("Failed creation: %v\n", err)
 		return c.JSON(http.StatusServiceUnavailable, "")
 	}
+	node.RegisterFunction(&f)
 	response := struct{ Created string }{f.Name}
 	return c.JSON(http.StatusOK, response)
 }
@@ -172,7 +273,8 @@ func DeleteFunction(c echo.Context) error {
 		return c.String(http.StatusServiceUnavailable, "")
 	}
 
-	// Delete local warm containers
+	// Delete local warm containers (no longer kept at all times)
+	node.UnregisterFunction(f.Name)
 	node.ShutdownWarmContainersFor(&f)
 
 	response := struct{ Deleted string }{f.Name}
@@ -208,6 +310,57 @@ func GetServerStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// GetPolicyState returns the internal state of the scheduling policy (e.g.,
+// what the "learning" policy has learned so far).
+func GetPolicyState(c echo.Context) error {
+	state, ok := scheduling.PolicyState()
+	if !ok {
+		return c.String(http.StatusNotFound, "The scheduling policy has no state to inspect")
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// defaultDecisionsLimit is the max number of decisions returned by default
+const defaultDecisionsLimit = 100
+
+// maxDecisionsLimit is the max number of decisions returned anyway
+const maxDecisionsLimit = 1000
+
+// GetDecisions returns the most recent scheduling decisions, possibly only
+// those about a function or a request.
+func GetDecisions(c echo.Context) error {
+	limit := defaultDecisionsLimit
+	if l := c.QueryParam("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			return c.String(http.StatusBadRequest, "Invalid limit")
+		}
+	}
+	if limit == 0 || limit > maxDecisionsLimit {
+		limit = maxDecisionsLimit
+	}
+
+	records, err := scheduling.QueryDecisions(c.QueryParam("function"), c.QueryParam("reqId"), limit)
+	if errors.Is(err, scheduling.DecisionLogDisabledErr) {
+		return c.String(http.StatusNotFound, "The decision log is not enabled")
+	} else if err != nil {
+		log.Printf("Could not read the decision log: %v\n", err)
+		return c.String(http.StatusInternalServerError, "Could not read the decision log")
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// GetAutoscalerState returns the arrival rates forecast by the autoscaler for
+// each function, along with the containers it has pre-warmed or destroyed.
+func GetAutoscalerState(c echo.Context) error {
+	forecasts, err := node.AutoscalerForecasts()
+	if errors.Is(err, node.AutoscalerDisabledErr) {
+		return c.String(http.StatusNotFound, "The autoscaler is not enabled")
+	}
+	return c.JSON(http.StatusOK, forecasts)
+}
+
 // PrewarmFunction handles a prewarming request.
 func PrewarmFunction(c echo.Context) error {
 	var req client.PrewarmingRequest
@@ -222,6 +375,9 @@ func PrewarmFunction(c echo.Context) error {
 		log.Printf("Dropping request for unknown fun '%s'\n", req.Function)
 		return c.String(http.StatusNotFound, "Function unknown")
 	}
+	if scheduling.IsDraining() {
+		return c.String(http.StatusServiceUnavailable, "Node is draining")
+	}
 
 	count, err := node.PrewarmInstances(fun, req.Instances, req.ForceImagePull)
 
@@ -232,3 +388,39 @@ func PrewarmFunction(c echo.Context) error {
 	response := struct{ Prewarmed int64 }{count}
 	return c.JSON(http.StatusOK, response)
 }
+
+// DrainNode handles a request to drain the node: the node stops accepting new
+// requests for local execution, waits for the requests in flight and then
+// terminates. The request must carry the admin token, if configured, or come
+// from the node host otherwise.
+func DrainNode(c echo.Context) error {
+	if !isAdmin(c) {
+		log.Printf("Refusing drain request from %s\n", c.RealIP())
+		return c.String(http.StatusForbidden, "Not allowed")
+	}
+	select {
+	case drainRequests <- struct{}{}:
+		log.Println("Drain requested")
+	default:
+		// already requested
+	}
+	return c.String(http.StatusAccepted, "Draining")
+}
+
+// isAdmin checks whether a request carries the admin token (as a bearer
+// token) or, if no token is configured, comes from the loopback interface.
+func isAdmin(c echo.Context) bool {
+	token := config.GetString(config.API_ADMIN_TOKEN, "")
+	if token == "" {
+		ip := net.ParseIP(c.RealIP())
+		return ip != nil && ip.IsLoopback()
+	}
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	return subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) == 1
+}
+
+// DrainRequests returns the channel notified when draining is requested
+// through the API.
+func DrainRequests() <-chan struct{} {
+	return drainRequests
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+func TestCheckEnvelope(t *testing.T) {
+	old := isPeer
+	isPeer = func(ip string) bool { return ip == "10.0.0.2" }
+	t.Cleanup(func() { isPeer = old })
+
+	tests := []struct {
+		name     string
+		callerIP string
+		envelope client.OffloadEnvelope
+		status   int
+		ok       bool
+	}{
+		{"from a node", "10.0.0.2", client.OffloadEnvelope{ReqId: "fib-1", Hops: 1, MaxHops: 2}, http.StatusOK, true},
+		{"from a client", "10.0.0.9", client.OffloadEnvelope{ReqId: "fib-1", Hops: 1, MaxHops: 2}, http.StatusForbidden, false},
+		{"without ReqId", "10.0.0.2", client.OffloadEnvelope{Hops: 1, MaxHops: 2}, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, ok := checkEnvelope(&tt.envelope, tt.callerIP)
+			if status != tt.status || ok != tt.ok {
+				t.Errorf("got (%d, %v), want (%d, %v)", status, ok, tt.status, tt.ok)
+			}
+		})
+	}
+}
+
+func TestApplyEnvelope(t *testing.T) {
+	tests := []struct {
+		name       string
+		envelope   client.OffloadEnvelope
+		maxHops    int
+		offloading function.OffloadingInfo
+		maxRespT   float64
+	}{
+		{"within the local max hops",
+			client.OffloadEnvelope{ReqId: "fib-1", Hops: 1, MaxHops: 2, Visited: []string{"http://edge1"}, RemainingRespT: 0.5}, 3,
+			function.OffloadingInfo{Hops: 1, MaxHops: 2, Visited: []string{"http://edge1"}}, 0.5},
+		{"capped at the local max hops",
+			client.OffloadEnvelope{ReqId: "fib-1", Hops: 1, MaxHops: 10, Visited: []string{"http://edge1"}}, 2,
+			function.OffloadingInfo{Hops: 1, MaxHops: 2, Visited: []string{"http://edge1"}}, 0},
+		{"no deadline",
+			client.OffloadEnvelope{ReqId: "fib-1", Hops: 2, MaxHops: 2, Visited: []string{"http://edge1", "http://edge2"}, RemainingRespT: 0}, 2,
+			function.OffloadingInfo{Hops: 2, MaxHops: 2, Visited: []string{"http://edge1", "http://edge2"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &function.Request{}
+			applyEnvelope(r, &tt.envelope, tt.maxHops)
+			if r.ReqId != tt.envelope.ReqId || r.MaxRespT != tt.maxRespT {
+				t.Errorf("got ReqId %s (max response time %v), want %s (%v)", r.ReqId, r.MaxRespT, tt.envelope.ReqId, tt.maxRespT)
+			}
+			if fmt.Sprintf("%+v", r.Offloading) != fmt.Sprintf("%+v", tt.offloading) {
+				t.Errorf("got %+v, want %+v", r.Offloading, tt.offloading)
+			}
+		})
+	}
+}
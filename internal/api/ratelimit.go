@@ -0,0 +1,179 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/labstack/echo/v4"
+)
+
+// maxBuckets is the number of buckets kept before forgetting the least
+// recently used ones
+const maxBuckets = 10000
+
+// InvalidBurstErr is returned when a rate limit is configured with a burst
+// smaller than one event
+var InvalidBurstErr = errors.New("the burst of a rate limit must be at least 1")
+
+// tokenBucket limits the rate of events to rate per second on average, with
+// bursts of at most burst events.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take consumes a token if available. Otherwise, it returns how long it takes
+// for a token to be available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1.0 {
+		b.tokens -= 1.0
+		return true, 0
+	}
+	wait := (1.0 - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// rateLimiter keeps a token bucket for each key (e.g., a function or a caller).
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64            // default rate (events/s); 0 means no limit
+	burst   float64            // default burst; 0 means as many events as the rate (at least 1)
+	rates   map[string]float64 // rates for specific keys (lowercase)
+	bursts  map[string]float64 // bursts for specific keys (lowercase)
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate, burst float64, rates map[string]float64, bursts map[string]float64) (*rateLimiter, error) {
+	if burst != 0.0 && burst < 1.0 {
+		return nil, InvalidBurstErr
+	}
+	for _, b := range bursts {
+		if b < 1.0 {
+			return nil, InvalidBurstErr
+		}
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		rates:   rates,
+		bursts:  bursts,
+		buckets: make(map[string]*tokenBucket),
+	}, nil
+}
+
+// limits returns the rate and burst for the given key. Keys with their own
+// rate, but no burst, may burst as many events as their rate.
+func (l *rateLimiter) limits(key string) (float64, float64) {
+	key = strings.ToLower(key)
+	rate, ok := l.rates[key]
+	burst := l.burst
+	if ok {
+		burst = 0.0
+	} else {
+		rate = l.rate
+	}
+	if b, ok := l.bursts[key]; ok {
+		burst = b
+	}
+	if burst <= 0.0 {
+		burst = math.Max(1.0, rate)
+	}
+	return rate, burst
+}
+
+// allow checks whether an event for the given key is allowed. Otherwise, it
+// returns how long to wait before retrying.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	rate, burst := l.limits(key)
+	if rate <= 0.0 {
+		return true, 0
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.forgetIdleBuckets()
+		}
+		b = &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	return b.take(now)
+}
+
+// forgetIdleBuckets removes the least recently used buckets, keeping 90% of
+// maxBuckets. Buckets idle for long are full again, and behave like new ones.
+// The function is NOT thread-safe.
+func (l *rateLimiter) forgetIdleBuckets() {
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last)
+	})
+	for _, key := range keys[:len(keys)-maxBuckets*9/10] {
+		delete(l.buckets, key)
+	}
+}
+
+var functionLimiter *rateLimiter
+var callerLimiter *rateLimiter
+
+// InitRateLimiting sets up the per-function and per-caller limits on the
+// invocation rate.
+func InitRateLimiting() error {
+	var err error
+	functionLimiter, err = newRateLimiter(config.GetFloat(config.API_RATELIMIT_FUNCTION_RATE, 0.0),
+		config.GetFloat(config.API_RATELIMIT_FUNCTION_BURST, 0.0),
+		config.GetFloatMap(config.API_RATELIMIT_FUNCTIONS),
+		config.GetFloatMap(config.API_RATELIMIT_FUNCTION_BURSTS))
+	if err != nil {
+		return err
+	}
+	callerLimiter, err = newRateLimiter(config.GetFloat(config.API_RATELIMIT_CALLER_RATE, 0.0),
+		config.GetFloat(config.API_RATELIMIT_CALLER_BURST, 0.0), nil, nil)
+	return err
+}
+
+// admitInvocation enforces the rate limits on an invocation request. If the
+// request is not admitted, it returns how long to wait before retrying.
+// Requests offloaded by other registered nodes are only subject to the
+// per-function limits.
+func admitInvocation(c echo.Context, funcName string, offloaded bool) (bool, time.Duration) {
+	if callerLimiter != nil && !offloaded {
+		if ok, wait := callerLimiter.allow(c.RealIP()); !ok {
+			return false, wait
+		}
+	}
+	if functionLimiter != nil {
+		return functionLimiter.allow(funcName)
+	}
+	return true, 0
+}
+
+// rejectInvocation replies with 429 and a Retry-After header.
+func rejectInvocation(c echo.Context, wait time.Duration) error {
+	retryAfter := int64(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	return c.String(http.StatusTooManyRequests, "Rate limit exceeded")
+}
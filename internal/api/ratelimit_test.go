@@ -0,0 +1,165 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+)
+
+// fakeClock is a clock moved forward by the tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) {}
+
+func useFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	clock.Set(c)
+	t.Cleanup(func() { clock.Set(clock.Real()) })
+	return c
+}
+
+func TestTokenBucket(t *testing.T) {
+	type event struct {
+		after   time.Duration // since the previous event
+		allowed bool
+		wait    time.Duration // if not allowed
+	}
+	tests := []struct {
+		name   string
+		rate   float64
+		burst  float64
+		events []event
+	}{
+		{"burst then refused", 1, 2, []event{
+			{0, true, 0}, {0, true, 0}, {0, false, time.Second},
+		}},
+		{"refill at rate", 2, 1, []event{
+			{0, true, 0}, {0, false, 500 * time.Millisecond},
+			{250 * time.Millisecond, false, 250 * time.Millisecond},
+			{250 * time.Millisecond, true, 0},
+		}},
+		{"refill up to burst", 10, 2, []event{
+			{0, true, 0}, {0, true, 0}, {time.Hour, true, 0}, {0, true, 0}, {0, false, 100 * time.Millisecond},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			b := &tokenBucket{rate: tt.rate, burst: tt.burst, tokens: tt.burst, last: now}
+			for i, e := range tt.events {
+				now = now.Add(e.after)
+				allowed, wait := b.take(now)
+				if allowed != e.allowed || wait != e.wait {
+					t.Errorf("event %d: got (%v, %v), want (%v, %v)", i, allowed, wait, e.allowed, e.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterLimits(t *testing.T) {
+	l, err := newRateLimiter(10, 20, map[string]float64{"fib": 2, "hello": 4}, map[string]float64{"hello": 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   string
+		rate  float64
+		burst float64
+	}{
+		{"other", 10, 20},
+		{"fib", 2, 2},   // own rate, burst as many as the rate
+		{"Fib", 2, 2},   // keys are case-insensitive
+		{"hello", 4, 8}, // own rate and burst
+	}
+	for _, tt := range tests {
+		rate, burst := l.limits(tt.key)
+		if rate != tt.rate || burst != tt.burst {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", tt.key, rate, burst, tt.rate, tt.burst)
+		}
+	}
+}
+
+func TestRateLimiterInvalidBurst(t *testing.T) {
+	tests := []struct {
+		burst  float64
+		bursts map[string]float64
+		valid  bool
+	}{
+		{0, nil, true},
+		{1, nil, true},
+		{0.5, nil, false},
+		{-1, nil, false},
+		{0, map[string]float64{"fib": 2}, true},
+		{0, map[string]float64{"fib": 0.5}, false},
+	}
+	for _, tt := range tests {
+		_, err := newRateLimiter(1, tt.burst, nil, tt.bursts)
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, InvalidBurstErr)) {
+			t.Errorf("burst %v, bursts %v: got %v", tt.burst, tt.bursts, err)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	c := useFakeClock(t)
+	l, _ := newRateLimiter(1, 1, map[string]float64{"unlimited": 0}, nil)
+
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("first event refused")
+	}
+	if ok, wait := l.allow("a"); ok || wait != time.Second {
+		t.Fatalf("second event: got (%v, %v)", ok, wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("keys are not limited separately")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow("unlimited"); !ok {
+			t.Fatal("event refused with no limit")
+		}
+	}
+	c.now = c.now.Add(time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("event refused after refill")
+	}
+}
+
+func TestForgetIdleBuckets(t *testing.T) {
+	c := useFakeClock(t)
+	l, _ := newRateLimiter(1, 1, nil, nil)
+
+	for i := 0; i < maxBuckets; i++ {
+		l.allow(fmt.Sprintf("caller-%d", i))
+		c.now = c.now.Add(time.Millisecond)
+	}
+	// the oldest callers are used again
+	l.allow("caller-0")
+	l.allow("caller-1")
+	l.allow("new")
+
+	if len(l.buckets) > maxBuckets*9/10+1 {
+		t.Fatalf("%d buckets kept", len(l.buckets))
+	}
+	for _, key := range []string{"caller-0", "caller-1", "new", fmt.Sprintf("caller-%d", maxBuckets-1)} {
+		if _, ok := l.buckets[key]; !ok {
+			t.Errorf("recently used bucket %s forgotten", key)
+		}
+	}
+	if _, ok := l.buckets["caller-2"]; ok {
+		t.Errorf("least recently used bucket kept")
+	}
+	// callers kept are still limited
+	if ok, _ := l.allow("caller-0"); ok {
+		t.Errorf("recently used bucket reset")
+	}
+}
@@ -63,8 +63,8 @@ var statusCmd = &cobra.Command{
 
 var funcName, runtime, handler, customImage, src, qosClass string
 var requestId string
-var memory int64
-var cpuDemand, qosMaxRespT float64
+var memory, maxConcurrency, reservedMemory, containerConcurrency, minWarm int64
+var cpuDemand, qosMaxRespT, timeout, reservedCPUs, keepAlive float64
 var params []string
 var paramsFile string
 var asyncInvocation bool
@@ -92,6 +92,13 @@ func Init() {
 	createCmd.Flags().Int64VarP(&memory, "memory", "", 128, "memory (in MB) for the function")
 	createCmd.Flags().Float64VarP(&cpuDemand, "cpu", "", 0.0, "estimated CPU demand for the function (1.0 = 1 core)")
 	createCmd.Flags().StringVarP(&src, "src", "", "", "source for the function (single file, directory or TAR archive) (not necessary for runtime==custom)")
+	createCmd.Flags().Int64VarP(&maxConcurrency, "max_concurrency", "", 0, "max number of containers for the function on each node (0 = no limit)")
+	createCmd.Flags().Float64VarP(&reservedCPUs, "reserved_cpu", "", 0.0, "CPU reserved to the function on each node")
+	createCmd.Flags().Int64VarP(&reservedMemory, "reserved_memory", "", 0, "memory (in MB) reserved to the function on each node")
+	createCmd.Flags().Int64VarP(&containerConcurrency, "container_concurrency", "", 1, "max number of concurrent invocations in each container")
+	createCmd.Flags().Float64VarP(&timeout, "timeout", "", 0.0, "max execution time (in seconds) for the function (0 = no limit)")
+	createCmd.Flags().Float64VarP(&keepAlive, "keepalive", "", 0.0, "idle time (in seconds) after which warm containers expire (0 = node default)")
+	createCmd.Flags().Int64VarP(&minWarm, "min_warm", "", 0, "containers kept warm on each node at all times")
 	createCmd.Flags().StringVarP(&customImage, "custom_image", "", "", "custom container image (only if runtime == 'custom')")
 
 	rootCmd.AddCommand(deleteCmd)
@@ -178,6 +185,10 @@ func invoke(cmd *cobra.Command, args []string) {
 	resp, err := utils.PostJson(url, invocationBody)
 	if err != nil {
 		fmt.Printf("Invocation failed: %v\n", err)
+		// e.g., the nodes tried to offload the request
+		if resp != nil && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			utils.PrintJsonResponse(resp.Body)
+		}
 		os.Exit(2)
 	}
 	utils.PrintJsonResponse(resp.Body)
@@ -207,9 +218,16 @@ func create(cmd *cobra.Command, args []string) {
 
 	request := function.Function{Name: funcName, Handler: handler,
 		Runtime: runtime, MemoryMB: memory,
-		CPUDemand:       cpuDemand,
-		TarFunctionCode: encoded,
-		CustomImage:     customImage,
+		CPUDemand:                  cpuDemand,
+		TarFunctionCode:            encoded,
+		CustomImage:                customImage,
+		Timeout:                    timeout,
+		MaxConcurrency:             maxConcurrency,
+		ReservedCPUs:               reservedCPUs,
+		ReservedMemMB:              reservedMemory,
+		MaxConcurrencyPerContainer: containerConcurrency,
+		KeepAlive:                  keepAlive,
+		MinWarm:                    minWarm,
 	}
 	requestBody, err := json.Marshal(request)
 	if err != nil {
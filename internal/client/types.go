@@ -7,6 +7,17 @@ type InvocationRequest struct {
 	CanDoOffloading bool
 	Async           bool
 	ReturnOutput    bool
+	Envelope        *OffloadEnvelope // only set when forwarded by another node
+}
+
+// OffloadEnvelope carries the information about a request that is being
+// offloaded from node to node.
+type OffloadEnvelope struct {
+	ReqId          string   // assigned by the node that first received the request
+	Hops           int      // number of times the request has been offloaded so far
+	MaxHops        int      // max number of times the request can be offloaded
+	Visited        []string // URLs of the nodes the request went through
+	RemainingRespT float64  // what is left of QoSMaxRespT (0 = no deadline)
 }
 
 type PrewarmingRequest struct {
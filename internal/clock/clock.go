@@ -0,0 +1,44 @@
+package clock
+
+import "time"
+
+// Clock tells the time to the node components (e.g., the scheduler), so that
+// they can run against a simulated time as well.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc waits for the duration to elapse and then calls f in its
+	// own goroutine.
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+var current Clock = realClock{}
+
+// Real returns the real clock.
+func Real() Clock {
+	return realClock{}
+}
+
+// Set replaces the clock in use (the real one, by default).
+func Set(c Clock) {
+	current = c
+}
+
+// Now returns the current time.
+func Now() time.Time {
+	return current.Now()
+}
+
+// AfterFunc calls f after d has elapsed.
+func AfterFunc(d time.Duration, f func()) {
+	current.AfterFunc(d, f)
+}
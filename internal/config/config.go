@@ -50,6 +50,20 @@ func GetBool(key string, defaultValue bool) bool {
 	}
 }
 
+// GetFloatMap returns the configured map of floats for a given key (e.g., a
+// weight for each function), or an empty map. Note that map keys are
+// lowercase.
+func GetFloatMap(key string) map[string]float64 {
+	values := make(map[string]float64)
+	if !viper.IsSet(key) {
+		return values
+	}
+	for k := range viper.GetStringMap(key) {
+		values[k] = viper.GetFloat64(key + "." + k)
+	}
+	return values
+}
+
 // ReadConfiguration reads a configuration file stored in one of the predefined paths.
 func ReadConfiguration(fileName string) {
 	// paths where the config file can be placed
@@ -6,6 +6,30 @@ const ETCD_ADDRESS = "etcd.address"
 //exposed port for serverledge APIs
 const API_PORT = "api.port"
 
+// Token required (as a bearer token) by the administrative API, e.g., draining (default: only local requests are allowed)
+const API_ADMIN_TOKEN = "api.admintoken"
+
+// Address range (CIDR) of a trusted reverse proxy, whose X-Forwarded-For header identifies the callers
+const API_TRUSTED_PROXY = "api.trustedproxy"
+
+// Max invocation rate (requests/s) for each function (0 = no limit)
+const API_RATELIMIT_FUNCTION_RATE = "api.ratelimit.function.rate"
+
+// Max burst of invocations for each function (default: as many as the rate)
+const API_RATELIMIT_FUNCTION_BURST = "api.ratelimit.function.burst"
+
+// Max invocation rate of specific functions (map: function name -> requests/s)
+const API_RATELIMIT_FUNCTIONS = "api.ratelimit.functions"
+
+// Max burst of invocations of specific functions (map: function name -> requests)
+const API_RATELIMIT_FUNCTION_BURSTS = "api.ratelimit.bursts"
+
+// Max invocation rate (requests/s) for each caller, i.e., client IP address (0 = no limit)
+const API_RATELIMIT_CALLER_RATE = "api.ratelimit.caller.rate"
+
+// Max burst of invocations for each caller (default: as many as the rate)
+const API_RATELIMIT_CALLER_BURST = "api.ratelimit.caller.burst"
+
 //REMOTE SERVER URL
 const CLOUD_URL = "cloud.server.url"
 
@@ -25,6 +49,24 @@ const POOL_CLEANUP_PERIOD = "janitor.interval"
 // container expiration time
 const CONTAINER_EXPIRATION_TIME = "container.expiration"
 
+// Learn the keep-alive of warm containers from the inter-arrival times of each function (true/false)
+const CONTAINER_KEEPALIVE_ADAPTIVE = "container.keepalive.adaptive"
+
+// Periodically check the liveness of warm containers, removing the dead ones (true/false)
+const CONTAINER_HEALTHCHECK = "container.healthcheck"
+
+// Check the liveness of warm containers before serving invocations (true/false)
+const CONTAINER_HEALTHCHECK_ON_ACQUIRE = "container.healthcheck.onacquire"
+
+// Time (in seconds) after becoming ready during which a warm container is not checked, e.g., while its Executor is starting
+const CONTAINER_HEALTHCHECK_GRACE = "container.healthcheck.grace"
+
+// Policy choosing the warm containers evicted to make room for new ones: default, lru, lfu, greedydual
+const CONTAINER_EVICTION_POLICY = "container.eviction"
+
+// Policy choosing the warm container serving an invocation: fifo, mru
+const CONTAINER_SELECTION_POLICY = "container.selection"
+
 // cache capacity
 const CACHE_SIZE = "cache.size"
 
@@ -59,8 +101,73 @@ const METRICS_PROMETHEUS_HOST = "metrics.prometheus.host"
 const METRICS_PROMETHEUS_PORT = "metrics.prometheus.port"
 
 // Scheduling policy to use
-// Possible values: "qosaware", "default", "cloudonly"
+// Possible values: "qosaware", "learning", "default", "cloudonly", "edgecloud", "edgeonly", "custom1"
 const SCHEDULING_POLICY = "scheduler.policy"
 
 // Capacity of the queue (possibly) used by the scheduler
 const SCHEDULER_QUEUE_CAPACITY = "scheduler.queue.capacity"
+
+// Ordering of the queue (possibly) used by the scheduler
+// Possible values: "fifo", "priority" (by service class and deadline)
+const SCHEDULER_QUEUE_POLICY = "scheduler.queue.policy"
+
+// Use a separate queue for each function, served with weighted fair queuing (true/false)
+const SCHEDULER_QUEUE_FAIR = "scheduler.queue.fair"
+
+// Weights of the functions for fair queuing (map: function name -> weight, default: 1)
+const SCHEDULER_QUEUE_WEIGHTS = "scheduler.queue.weights"
+
+// Max time (in seconds) a request without deadline can wait in the scheduler queue (0 = no limit)
+const SCHEDULER_QUEUE_MAX_WAIT = "scheduler.queue.maxwait"
+
+// Serve LOW class requests in best-effort mode, i.e., only using spare
+// resources and letting other requests preempt them in the queue (true/false)
+const SCHEDULER_BEST_EFFORT = "scheduler.besteffort"
+
+// Max number of nodes to try when offloading a request (the selected one,
+// then other nearby nodes and the Cloud)
+const SCHEDULER_OFFLOAD_ATTEMPTS = "scheduler.offload.attempts"
+
+// Max number of times a request received by this node can be offloaded
+// from node to node (e.g., 2 allows Edge -> Edge -> Cloud)
+const SCHEDULER_OFFLOAD_MAX_HOPS = "scheduler.offload.maxhops"
+
+// Smoothing factor (0-1) of the response time estimates kept by the "qosaware" policy
+const SCHEDULER_QOSAWARE_ALPHA = "scheduler.qosaware.alpha"
+
+// Max time (in seconds) to wait for the requests in flight when draining the node
+const DRAIN_TIMEOUT = "drain.timeout"
+
+// Smoothing factor (0-1) of the cost estimates kept by the "learning" policy
+const SCHEDULER_LEARNING_ALPHA = "scheduler.learning.alpha"
+
+// Fraction of requests (0-1) for which the "learning" policy explores a random execution site
+const SCHEDULER_LEARNING_EPSILON = "scheduler.learning.epsilon"
+
+// Enable the autoscaler, which pre-warms containers based on the forecast arrival rates (true/false)
+const AUTOSCALER_ENABLED = "autoscaler.enabled"
+
+// Interval (in seconds) between autoscaling rounds
+const AUTOSCALER_INTERVAL = "autoscaler.interval"
+
+// Smoothing factors (0-1) of the arrival rate level and trend forecast by the autoscaler
+const AUTOSCALER_ALPHA = "autoscaler.alpha"
+const AUTOSCALER_BETA = "autoscaler.beta"
+
+// Extra fraction of containers kept by the autoscaler beyond those needed for the forecast arrivals
+const AUTOSCALER_HEADROOM = "autoscaler.headroom"
+
+// Forward requests with no local warm container to nearby nodes having one, if faster than a cold start (true/false)
+const SCHEDULER_WARM_ROUTING = "scheduler.warmrouting"
+
+// File where every scheduling decision is recorded as JSON lines (empty to disable)
+const SCHEDULER_AUDIT_FILE = "scheduler.audit.file"
+
+// Size (MB) above which the scheduling decision log is rotated
+const SCHEDULER_AUDIT_MAX_SIZE = "scheduler.audit.maxsize"
+
+// Number of rotated scheduling decision logs to keep
+const SCHEDULER_AUDIT_BACKUPS = "scheduler.audit.backups"
+
+// Log every scheduling decision when running the simulator (true/false)
+const SIMULATION_VERBOSE = "simulation.verbose"
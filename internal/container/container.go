@@ -2,17 +2,32 @@ package container
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/executor"
 )
 
+// executorTimeoutGrace is how long the node waits for the Executor to report
+// a timeout, after the function timeout has expired
+const executorTimeoutGrace = 2 * time.Second
+
+// healthCheckTimeout bounds the wait for the Executor to reply to a liveness
+// check
+const healthCheckTimeout = 1 * time.Second
+
+// ContainerNotRunningErr is returned for containers that have crashed or have
+// been killed
+var ContainerNotRunningErr = errors.New("the container is not running")
+
 // NewContainer creates and starts a new container.
 func NewContainer(image, codeTar string, opts *ContainerOptions) (ContainerID, error) {
 	contID, err := cf.Create(image, opts)
@@ -39,19 +54,28 @@ func NewContainer(image, codeTar string, opts *ContainerOptions) (ContainerID, e
 }
 
 // Execute interacts with the Executor running in the container to invoke the
-// function through a HTTP request.
-func Execute(contID ContainerID, req *executor.InvocationRequest) (*executor.InvocationResult, time.Duration, error) {
+// function through a HTTP request. The request is cancelled (and the
+// execution aborted) as soon as ctx is done.
+// If the request has a timeout, the Executor is expected to kill the function
+// when exceeded; if it does not reply in time anyway, the result is a timeout
+// as well.
+func Execute(ctx context.Context, contID ContainerID, req *executor.InvocationRequest) (*executor.InvocationResult, time.Duration, error) {
 	ipAddr, err := cf.GetIPAddress(contID)
 	if err != nil {
 		return nil, 0, fmt.Errorf("Failed to retrieve IP address for container: %v", err)
 	}
 
 	postBody, _ := json.Marshal(req)
-	postBodyB := bytes.NewBuffer(postBody)
-	resp, waitDuration, err := sendPostRequestWithRetries(fmt.Sprintf("http://%s:%d/invoke", ipAddr,
-		executor.DEFAULT_EXECUTOR_PORT), postBodyB)
-	if err != nil || resp == nil {
-		return nil, waitDuration, fmt.Errorf("Request to executor failed: %v", err)
+	var timeout time.Duration
+	if req.Timeout > 0.0 {
+		timeout = time.Duration(req.Timeout*float64(time.Second)) + executorTimeoutGrace
+	}
+	resp, waitDuration, err := sendPostRequestWithRetries(ctx, contID, fmt.Sprintf("http://%s:%d/invoke", ipAddr,
+		executor.DEFAULT_EXECUTOR_PORT), postBody, timeout)
+	if ctx.Err() == nil && isTimeout(err) {
+		return &executor.InvocationResult{Success: false, TimedOut: true}, waitDuration, nil
+	} else if err != nil || resp == nil {
+		return nil, waitDuration, fmt.Errorf("Request to executor failed: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -70,6 +94,33 @@ func Execute(contID ContainerID, req *executor.InvocationRequest) (*executor.Inv
 	return response, waitDuration, nil
 }
 
+// CheckHealth returns an error if a container is not running, or its
+// Executor does not reply.
+func CheckHealth(contID ContainerID) error {
+	running, err := cf.IsRunning(contID)
+	if err != nil {
+		return err
+	} else if !running {
+		return ContainerNotRunningErr
+	}
+	if _, simulated := cf.(*SimulatedFactory); simulated {
+		return nil // no Executor to check
+	}
+
+	ipAddr, err := cf.GetIPAddress(contID)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve IP address for container: %v", err)
+	}
+	client := &http.Client{Timeout: healthCheckTimeout}
+	resp, err := client.Get(fmt.Sprintf("http://%s:%d/health", ipAddr, executor.DEFAULT_EXECUTOR_PORT))
+	if err != nil {
+		return fmt.Errorf("Executor not reachable: %w", err)
+	}
+	// any reply will do (Executors predating the health check reply 404)
+	_ = resp.Body.Close()
+	return nil
+}
+
 func GetMemoryMB(id ContainerID) (int64, error) {
 	return cf.GetMemoryMB(id)
 }
@@ -78,7 +129,17 @@ func Destroy(id ContainerID) error {
 	return cf.Destroy(id)
 }
 
-func sendPostRequestWithRetries(url string, body *bytes.Buffer) (*http.Response, time.Duration, error) {
+// isTimeout checks whether err is due to a timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// sendPostRequestWithRetries sends a request to the Executor, retrying while
+// the Executor is not reachable (e.g., it is still starting), unless the
+// container is found not running. Each attempt fails if no reply is received
+// within the timeout (if positive).
+func sendPostRequestWithRetries(ctx context.Context, contID ContainerID, url string, body []byte, timeout time.Duration) (*http.Response, time.Duration, error) {
 	const TIMEOUT_MILLIS = 30000
 	const MAX_BACKOFF_MILLIS = 500
 	var backoffMillis = 25
@@ -87,17 +148,35 @@ func sendPostRequestWithRetries(url string, body *bytes.Buffer) (*http.Response,
 
 	var err error
 
+	client := http.DefaultClient
+	if timeout > 0 {
+		client = &http.Client{Timeout: timeout}
+	}
+
 	for totalWaitMillis < TIMEOUT_MILLIS {
-		resp, err := http.Post(url, "application/json", body)
-		if err == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err == nil || isTimeout(err) {
+			// the Executor was reached (or it is stuck): do not retry
 			return resp, time.Duration(totalWaitMillis * int(time.Millisecond)), err
 		} else if attempts > 3 {
 			// It is common to have a failure after a cold start, so
 			// we avoid logging failures on the first attempt(s)
+			if running, err := cf.IsRunning(contID); err == nil && !running {
+				return nil, time.Duration(totalWaitMillis * int(time.Millisecond)), ContainerNotRunningErr
+			}
 			log.Printf("Warning: Retrying POST to executor (attempts: %d): %v\n", attempts, err)
 		}
 
-		time.Sleep(time.Duration(backoffMillis * int(time.Millisecond)))
+		select {
+		case <-ctx.Done():
+			return nil, time.Duration(totalWaitMillis * int(time.Millisecond)), ctx.Err()
+		case <-time.After(time.Duration(backoffMillis * int(time.Millisecond))):
+		}
 		totalWaitMillis += backoffMillis
 		attempts += 1
 
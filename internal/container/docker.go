@@ -9,6 +9,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/grussorusso/serverledge/internal/config"
 	//	"github.com/docker/docker/pkg/stdcopy"
@@ -44,10 +45,11 @@ func (cf *DockerFactory) Create(image string, opts *ContainerOptions) (Container
 	}
 
 	resp, err := cf.cli.ContainerCreate(cf.ctx, &container.Config{
-		Image: image,
-		Cmd:   opts.Cmd,
-		Env:   opts.Env,
-		Tty:   false,
+		Image:  image,
+		Cmd:    opts.Cmd,
+		Env:    opts.Env,
+		Tty:    false,
+		Labels: opts.Labels,
 	}, &container.HostConfig{Resources: contResources}, nil, nil, "")
 
 	id := resp.ID
@@ -120,6 +122,32 @@ func (cf *DockerFactory) GetIPAddress(contID ContainerID) (string, error) {
 	return contJson.NetworkSettings.IPAddress, nil
 }
 
+func (cf *DockerFactory) IsRunning(contID ContainerID) (bool, error) {
+	contJson, err := cf.cli.ContainerInspect(cf.ctx, contID)
+	if client.IsErrNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return contJson.State != nil && contJson.State.Running, nil
+}
+
+func (cf *DockerFactory) List(label string, value string) ([]ContainerInfo, error) {
+	containers, err := cf.cli.ContainerList(cf.ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", label, value))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		infos = append(infos, ContainerInfo{ID: c.ID, Labels: c.Labels, Running: c.State == "running"})
+	}
+	return infos, nil
+}
+
 func (cf *DockerFactory) GetMemoryMB(contID ContainerID) (int64, error) {
 	contJson, err := cf.cli.ContainerInspect(cf.ctx, contID)
 	if err != nil {
@@ -14,14 +14,36 @@ type Factory interface {
 	PullImage(string) error
 	GetIPAddress(ContainerID) (string, error)
 	GetMemoryMB(id ContainerID) (int64, error)
+	// IsRunning returns false for containers that have exited or have been
+	// removed (e.g., crashed or killed externally).
+	IsRunning(ContainerID) (bool, error)
+	// List returns the containers (including stopped ones) having a label
+	// with the given value.
+	List(label string, value string) ([]ContainerInfo, error)
 }
 
+// Labels of the containers created by the node
+const (
+	NodeLabel     = "serverledge.node"     // identifier of the node
+	AddressLabel  = "serverledge.address"  // URL of the node API
+	FunctionLabel = "serverledge.function" // name of the function
+	CodeHashLabel = "serverledge.codehash" // hash of the function code
+)
+
 // ContainerOptions contains options for container creation.
 type ContainerOptions struct {
 	Cmd      []string
 	Env      []string
 	MemoryMB int64
 	CPUQuota float64
+	Labels   map[string]string
+}
+
+// ContainerInfo describes an existing container.
+type ContainerInfo struct {
+	ID      ContainerID
+	Labels  map[string]string
+	Running bool
 }
 
 type ContainerID = string
@@ -29,6 +51,12 @@ type ContainerID = string
 // cf is the container factory for the node
 var cf Factory
 
+// List returns the containers (including stopped ones) having a label with the
+// given value.
+func List(label string, value string) ([]ContainerInfo, error) {
+	return cf.List(label, value)
+}
+
 func DownloadImage(image string, forceRefresh bool) error {
 	if forceRefresh || !cf.HasImage(image) {
 		return cf.PullImage(image)
@@ -0,0 +1,128 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SimulatedFactory creates fake containers, which only exist in memory.
+// It is used to run the scheduler in simulation mode.
+type SimulatedFactory struct {
+	sync.Mutex
+	generation int
+	nextID     int
+	containers map[ContainerID]*simulatedContainer
+	// Latency is the duration of each operation on a container (e.g., to
+	// emulate the Docker API in benchmarks)
+	Latency time.Duration
+}
+
+type simulatedContainer struct {
+	memMB  int64
+	labels map[string]string
+}
+
+// simulatedFactories is the number of factories initialized so far
+var simulatedFactories = 0
+
+// InitSimulatedContainerFactory replaces the container factory with a new
+// SimulatedFactory. The IDs of its containers differ from those of the
+// previous factories, so that containers destroyed late (e.g., in the
+// background after a simulation) cannot be mistaken for new ones.
+func InitSimulatedContainerFactory() *SimulatedFactory {
+	simulatedFactories++
+	simFact := &SimulatedFactory{generation: simulatedFactories, containers: make(map[ContainerID]*simulatedContainer)}
+	cf = simFact
+	return simFact
+}
+
+// wait emulates the latency of an operation.
+func (cf *SimulatedFactory) wait() {
+	if cf.Latency > 0 {
+		time.Sleep(cf.Latency)
+	}
+}
+
+func (cf *SimulatedFactory) Create(image string, opts *ContainerOptions) (ContainerID, error) {
+	cf.wait()
+	cf.Lock()
+	defer cf.Unlock()
+
+	cf.nextID++
+	id := fmt.Sprintf("sim-%d-%d", cf.generation, cf.nextID)
+	cf.containers[id] = &simulatedContainer{memMB: opts.MemoryMB, labels: opts.Labels}
+	return id, nil
+}
+
+func (cf *SimulatedFactory) CopyToContainer(contID ContainerID, content io.Reader, destPath string) error {
+	return nil
+}
+
+func (cf *SimulatedFactory) Start(contID ContainerID) error {
+	return nil
+}
+
+func (cf *SimulatedFactory) Destroy(contID ContainerID) error {
+	cf.wait()
+	cf.Lock()
+	defer cf.Unlock()
+
+	delete(cf.containers, contID)
+	return nil
+}
+
+func (cf *SimulatedFactory) IsRunning(contID ContainerID) (bool, error) {
+	cf.Lock()
+	defer cf.Unlock()
+
+	_, ok := cf.containers[contID]
+	return ok, nil
+}
+
+// Kill terminates a container behind the back of the node (e.g., to emulate
+// a crash).
+func (cf *SimulatedFactory) Kill(contID ContainerID) {
+	cf.Lock()
+	defer cf.Unlock()
+
+	delete(cf.containers, contID)
+}
+
+func (cf *SimulatedFactory) HasImage(image string) bool {
+	return true
+}
+
+func (cf *SimulatedFactory) PullImage(image string) error {
+	return nil
+}
+
+func (cf *SimulatedFactory) GetIPAddress(contID ContainerID) (string, error) {
+	return "", fmt.Errorf("simulated container %s has no address", contID)
+}
+
+func (cf *SimulatedFactory) List(label string, value string) ([]ContainerInfo, error) {
+	cf.Lock()
+	defer cf.Unlock()
+
+	infos := make([]ContainerInfo, 0)
+	for id, c := range cf.containers {
+		if v, ok := c.labels[label]; ok && v == value {
+			infos = append(infos, ContainerInfo{ID: id, Labels: c.labels, Running: true})
+		}
+	}
+	return infos, nil
+}
+
+func (cf *SimulatedFactory) GetMemoryMB(contID ContainerID) (int64, error) {
+	cf.wait()
+	cf.Lock()
+	defer cf.Unlock()
+
+	c, ok := cf.containers[contID]
+	if !ok {
+		return 0, fmt.Errorf("unknown container %s", contID)
+	}
+	return c.memMB, nil
+}
@@ -1,17 +1,24 @@
 package executor
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
-const resultFile = "/tmp/_executor_result.json"
-const paramsFile = "/tmp/_executor.params"
+const resultFileName = "_executor_result.json"
+const paramsFileName = "_executor.params"
+
+// killWaitDelay bounds the wait for the output of a killed handler
+const killWaitDelay = 1 * time.Second
 
 func readExecutionResult(resultFile string) string {
 	content, err := os.ReadFile(resultFile)
@@ -23,6 +30,34 @@ func readExecutionResult(resultFile string) string {
 	return string(content)
 }
 
+// invocationEnv returns the environment of the handler process for an
+// invocation, writing the parameters to a file in workDir.
+// The variables are only set for the handler process (not for the executor),
+// so that concurrent invocations do not interfere with each other.
+func invocationEnv(req *InvocationRequest, workDir string, resultFile string) ([]string, error) {
+	env := append(os.Environ(),
+		"RESULT_FILE="+resultFile,
+		"HANDLER="+req.Handler,
+		"HANDLER_DIR="+req.HandlerDir)
+
+	if req.Params == nil {
+		return append(env, "PARAMS_FILE="), nil
+	}
+	paramsFile := filepath.Join(workDir, paramsFileName)
+	paramsB, _ := json.Marshal(req.Params)
+	if err := os.WriteFile(paramsFile, paramsB, 0644); err != nil {
+		log.Printf("Could not write parameters to %s\n", paramsFile)
+		return nil, err
+	}
+	return append(env, "PARAMS_FILE="+paramsFile), nil
+}
+
+// HealthHandler replies as long as the Executor is running, so that the node
+// can check the liveness of warm containers.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
 func InvokeHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request
 	reqDecoder := json.NewDecoder(r.Body)
@@ -33,25 +68,21 @@ func InvokeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set environment variables
-	err = os.Setenv("RESULT_FILE", resultFile)
-	err = errors.Join(err, os.Setenv("HANDLER", req.Handler))
-	err = errors.Join(err, os.Setenv("HANDLER_DIR", req.HandlerDir))
-	params := req.Params
-	if params == nil {
-		err = errors.Join(err, os.Setenv("PARAMS_FILE", ""))
-	} else {
-		paramsB, _ := json.Marshal(req.Params)
-		fileError := os.WriteFile(paramsFile, paramsB, 0644)
-		if fileError != nil {
-			log.Printf("Could not write parameters to %s\n", paramsFile)
-			http.Error(w, fileError.Error(), http.StatusInternalServerError)
-			return
-		}
-		err = errors.Join(err, os.Setenv("PARAMS_FILE", paramsFile))
+	// Each invocation has its own files, as the container may serve
+	// several invocations concurrently
+	workDir, err := os.MkdirTemp("", "invocation-")
+	if err != nil {
+		log.Printf("Could not create the invocation directory: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	defer os.RemoveAll(workDir)
+	resultFile := filepath.Join(workDir, resultFileName)
+
+	env, err := invocationEnv(req, workDir, resultFile)
 	if err != nil {
-		log.Printf("Error while setting environment variables: %s\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Exec handler process
@@ -62,17 +93,40 @@ func InvokeHandler(w http.ResponseWriter, r *http.Request) {
 		customCmd, ok := os.LookupEnv("CUSTOM_CMD")
 		if !ok {
 			log.Printf("Invalid request!\n")
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "no command to run", http.StatusBadRequest)
 			return
 		}
 
 		cmd = strings.Split(customCmd, " ")
 	}
 
+	// the handler is killed if the node gives up on the request, or if it
+	// runs for too long
+	ctx := r.Context()
+	if req.Timeout > 0.0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Timeout*float64(time.Second)))
+		defer cancel()
+	}
+
 	var resp *InvocationResult
-	execCmd := exec.Command(cmd[0], cmd[1:]...)
+	execCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	execCmd.Env = env
+	// the handler gets its own process group, so that any process it spawns
+	// is killed as well
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	execCmd.Cancel = func() error {
+		return syscall.Kill(-execCmd.Process.Pid, syscall.SIGKILL)
+	}
+	execCmd.WaitDelay = killWaitDelay
 	out, err := execCmd.CombinedOutput()
-	if err != nil {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("Handler killed after %.3f s\n", req.Timeout)
+		resp = &InvocationResult{Success: false, TimedOut: true}
+		if req.ReturnOutput {
+			resp.Output = string(out)
+		}
+	} else if err != nil {
 		log.Printf("cmd.Run() failed with %s\n", err)
 		if req.ReturnOutput {
 			resp = &InvocationResult{Success: false, Output: string(out)}
@@ -83,9 +137,9 @@ func InvokeHandler(w http.ResponseWriter, r *http.Request) {
 		result := readExecutionResult(resultFile)
 
 		if req.ReturnOutput {
-			resp = &InvocationResult{true, result, string(out)}
+			resp = &InvocationResult{Success: true, Result: result, Output: string(out)}
 		} else {
-			resp = &InvocationResult{true, result, ""}
+			resp = &InvocationResult{Success: true, Result: result, Output: ""}
 		}
 	}
 
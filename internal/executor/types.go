@@ -6,10 +6,14 @@ type InvocationRequest struct {
 	Handler      string
 	HandlerDir   string
 	ReturnOutput bool
+	Timeout      float64 // max execution time (s); 0 means no limit
 }
 
 type InvocationResult struct {
 	Success bool
 	Result  string
 	Output  string
+	// TimedOut is set if the handler has been killed for exceeding the
+	// timeout
+	TimedOut bool
 }
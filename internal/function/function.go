@@ -1,8 +1,11 @@
 package function
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/cache"
@@ -13,13 +16,31 @@ import (
 
 // Function describes a serverless function.
 type Function struct {
-	Name            string
-	Runtime         string  // example: python310
-	MemoryMB        int64   // MB
-	CPUDemand       float64 // 1.0 -> 1 core
-	Handler         string  // example: "module.function_name"
-	TarFunctionCode string  // input is .tar
-	CustomImage     string  // used if custom runtime is chosen
+	Name                       string
+	Runtime                    string  // example: python310
+	MemoryMB                   int64   // MB
+	CPUDemand                  float64 // 1.0 -> 1 core
+	Handler                    string  // example: "module.function_name"
+	TarFunctionCode            string  // input is .tar
+	CustomImage                string  // used if custom runtime is chosen
+	Timeout                    float64 // max execution time (s); 0 means no limit
+	MaxConcurrency             int64   // max number of containers; 0 means no limit
+	ReservedCPUs               float64 // CPUs that other functions cannot take
+	ReservedMemMB              int64   // memory (MB) that other functions cannot take
+	MaxConcurrencyPerContainer int64   // max concurrent invocations in a container; 0 means 1
+	KeepAlive                  float64 // idle time (s) before a warm container expires; 0 means the node default
+	MinWarm                    int64   // containers kept on each node at all times
+}
+
+// CodeHash identifies the code of the function (along with its runtime and
+// handler), so that containers can be matched with the function definition.
+func (f *Function) CodeHash() string {
+	h := sha256.New()
+	for _, s := range []string{f.Runtime, f.CustomImage, f.Handler, f.TarFunctionCode} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func (f *Function) getEtcdKey() string {
@@ -146,3 +167,87 @@ func GetAll() ([]string, error) {
 
 	return functions, nil
 }
+
+// watchRetryInterval is how long to wait before watching the functions again,
+// after the watch failed
+const watchRetryInterval = 5 * time.Second
+
+// Watch calls onPut for each function stored in Etcd, and then (in the
+// background) for each function stored afterwards by any node, and onDelete
+// for each function deleted. If watching fails, the functions are listed
+// again.
+func Watch(onPut func(*Function), onDelete func(name string)) {
+	rev, err := listAll(onPut)
+	if err != nil {
+		log.Printf("Could not retrieve functions: %v\n", err)
+	}
+	go watch(rev, onPut, onDelete)
+}
+
+// listAll calls onPut for each function stored, and returns the Etcd
+// revision they were retrieved at.
+func listAll(onPut func(*Function)) (int64, error) {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := cli.Get(ctx, "/function/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range resp.Kvs {
+		var f Function
+		if err = json.Unmarshal(kv.Value, &f); err == nil {
+			onPut(&f)
+		}
+	}
+	return resp.Header.Revision, nil
+}
+
+// watch notifies the changes after revision rev (0 to list the functions
+// first).
+func watch(rev int64, onPut func(*Function), onDelete func(name string)) {
+	for {
+		if rev == 0 {
+			var err error
+			if rev, err = listAll(onPut); err != nil {
+				time.Sleep(watchRetryInterval)
+				continue
+			}
+		}
+		cli, err := utils.GetEtcdClient()
+		if err != nil {
+			time.Sleep(watchRetryInterval)
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		watchChan := cli.Watch(clientv3.WithRequireLeader(ctx), "/function/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for watchResp := range watchChan {
+			if err = watchResp.Err(); err != nil {
+				log.Printf("Watching functions failed: %v\n", err)
+				break
+			}
+			for _, event := range watchResp.Events {
+				switch event.Type {
+				case clientv3.EventTypePut:
+					var f Function
+					if err = json.Unmarshal(event.Kv.Value, &f); err == nil {
+						onPut(&f)
+					}
+				case clientv3.EventTypeDelete:
+					onDelete(string(event.Kv.Key)[len("/function/"):])
+				}
+			}
+			rev = watchResp.Header.Revision
+		}
+		cancel()
+
+		// some changes may have been missed (e.g., compacted)
+		rev = 0
+		time.Sleep(watchRetryInterval)
+	}
+}
@@ -16,6 +16,25 @@ type Request struct {
 	CanDoOffloading bool
 	Async           bool
 	ReturnOutput    bool
+	Offloading      OffloadingInfo
+}
+
+// OffloadingInfo tracks the nodes a request has gone through.
+type OffloadingInfo struct {
+	Hops    int
+	MaxHops int
+	Visited []string // URLs of the nodes that forwarded the request
+}
+
+// HasVisited returns true if the request has been forwarded by the node with
+// the given URL.
+func (o *OffloadingInfo) HasVisited(url string) bool {
+	for _, v := range o.Visited {
+		if v == url {
+			return true
+		}
+	}
+	return false
 }
 
 type RequestQoS struct {
@@ -24,14 +43,23 @@ type RequestQoS struct {
 }
 
 type ExecutionReport struct {
-	Result         string
-	ResponseTime   float64
-	IsWarmStart    bool
-	InitTime       float64
-	OffloadLatency float64
-	Duration       float64
-	SchedAction    string
-	Output         string
+	Result          string
+	ResponseTime    float64
+	IsWarmStart     bool
+	InitTime        float64
+	OffloadLatency  float64
+	Duration        float64
+	SchedAction     string
+	Output          string
+	OffloadAttempts []OffloadAttempt
+}
+
+// OffloadAttempt describes an attempt to offload a request to another node.
+type OffloadAttempt struct {
+	Url     string
+	Success bool
+	Error   string
+	Elapsed float64 // seconds
 }
 
 type Response struct {
@@ -54,3 +82,15 @@ const (
 	HIGH_PERFORMANCE               = 1
 	HIGH_AVAILABILITY              = 2
 )
+
+// String returns the name of the service class (as accepted by the CLI).
+func (c ServiceClass) String() string {
+	switch c {
+	case HIGH_PERFORMANCE:
+		return "performance"
+	case HIGH_AVAILABILITY:
+		return "availability"
+	default:
+		return "low"
+	}
+}
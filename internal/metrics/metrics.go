@@ -65,4 +65,39 @@ func AddFunctionDurationValue(funcName string, duration float64) {
 func registerGlobalMetrics() {
 	registry.MustRegister(CompletedInvocations)
 	registry.MustRegister(ExecutionTimes)
+	registry.MustRegister(autoscalerCollector{})
+}
+
+// Autoscaler metrics, collected from the autoscaler state upon scraping
+var (
+	autoscalerForecast = prometheus.NewDesc("sedge_autoscaler_forecast",
+		"Arrival rate (req/s) forecast by the autoscaler", []string{"node", "function"}, nil)
+	autoscalerTarget = prometheus.NewDesc("sedge_autoscaler_target",
+		"Containers needed for the forecast arrivals", []string{"node", "function"}, nil)
+	autoscalerPrewarmed = prometheus.NewDesc("sedge_autoscaler_prewarmed_total",
+		"The total number of containers pre-warmed by the autoscaler", []string{"node", "function"}, nil)
+	autoscalerScaledDown = prometheus.NewDesc("sedge_autoscaler_scaleddown_total",
+		"The total number of idle containers destroyed by the autoscaler", []string{"node", "function"}, nil)
+)
+
+type autoscalerCollector struct{}
+
+func (autoscalerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- autoscalerForecast
+	ch <- autoscalerTarget
+	ch <- autoscalerPrewarmed
+	ch <- autoscalerScaledDown
+}
+
+func (autoscalerCollector) Collect(ch chan<- prometheus.Metric) {
+	forecasts, err := node.AutoscalerForecasts()
+	if err != nil {
+		return
+	}
+	for name, f := range forecasts {
+		ch <- prometheus.MustNewConstMetric(autoscalerForecast, prometheus.GaugeValue, f.Forecast, nodeIdentifier, name)
+		ch <- prometheus.MustNewConstMetric(autoscalerTarget, prometheus.GaugeValue, float64(f.Target), nodeIdentifier, name)
+		ch <- prometheus.MustNewConstMetric(autoscalerPrewarmed, prometheus.CounterValue, float64(f.Prewarmed), nodeIdentifier, name)
+		ch <- prometheus.MustNewConstMetric(autoscalerScaledDown, prometheus.CounterValue, float64(f.ScaledDown), nodeIdentifier, name)
+	}
 }
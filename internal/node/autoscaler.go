@@ -0,0 +1,237 @@
+package node
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// autoscalerMinConcurrency is the expected concurrency below which a function
+// is considered idle
+const autoscalerMinConcurrency = 0.05
+
+// AutoscalerDisabledErr is returned when the autoscaler state is requested,
+// but the autoscaler is not enabled
+var AutoscalerDisabledErr = errors.New("the autoscaler is not enabled")
+
+// FunctionForecast is the state of the autoscaler for a function.
+type FunctionForecast struct {
+	ArrivalRate float64 // observed in the last interval (req/s)
+	Forecast    float64 // expected in the next interval (req/s)
+	Trend       float64 // expected change of the arrival rate per interval (req/s)
+	Duration    float64 // estimated (s)
+	Target      int64   // containers needed for the expected arrivals
+	Containers  int64   // containers at the last round
+	Prewarmed   int64   // containers pre-warmed so far
+	ScaledDown  int64   // idle containers destroyed so far
+
+	level           float64
+	lastInvocations int64
+	initialized     bool
+}
+
+// observe updates the forecast (through Holt's double exponential
+// smoothing) with the arrival rate observed in the last interval.
+func (ff *FunctionForecast) observe(rate float64, alpha float64, beta float64) {
+	ff.ArrivalRate = rate
+	if !ff.initialized {
+		ff.level = rate
+		ff.Trend = 0.0
+		ff.initialized = true
+	} else {
+		level := alpha*rate + (1.0-alpha)*(ff.level+ff.Trend)
+		ff.Trend = beta*(level-ff.level) + (1.0-beta)*ff.Trend
+		ff.level = level
+	}
+	ff.Forecast = math.Max(0.0, ff.level+ff.Trend)
+}
+
+type autoscaler struct {
+	sync.Mutex
+	interval  time.Duration
+	alpha     float64
+	beta      float64
+	headroom  float64
+	functions map[string]*FunctionForecast
+	stop      chan struct{}
+}
+
+var scaler *autoscaler
+
+// InitAutoscaler configures the autoscaler, if enabled. The rounds are either
+// run by StartAutoscaler or (e.g., by the simulator) calling Autoscale.
+func InitAutoscaler() bool {
+	if !config.GetBool(config.AUTOSCALER_ENABLED, false) {
+		scaler = nil
+		return false
+	}
+	scaler = &autoscaler{
+		interval:  time.Duration(config.GetInt(config.AUTOSCALER_INTERVAL, 10)) * time.Second,
+		alpha:     config.GetFloat(config.AUTOSCALER_ALPHA, 0.5),
+		beta:      config.GetFloat(config.AUTOSCALER_BETA, 0.3),
+		headroom:  config.GetFloat(config.AUTOSCALER_HEADROOM, 0.2),
+		functions: make(map[string]*FunctionForecast),
+		stop:      make(chan struct{}),
+	}
+	return true
+}
+
+// AutoscalerInterval returns the interval between autoscaling rounds.
+func AutoscalerInterval() time.Duration {
+	return scaler.interval
+}
+
+// StartAutoscaler runs a round of autoscaling every interval, until
+// StopAutoscaler is called.
+func StartAutoscaler() {
+	if scaler == nil {
+		return
+	}
+	log.Printf("Autoscaler started (every %v)\n", scaler.interval)
+	go func(s *autoscaler) {
+		ticker := time.NewTicker(s.interval)
+		for {
+			select {
+			case <-ticker.C:
+				Autoscale()
+			case <-s.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}(scaler)
+}
+
+// StopAutoscaler stops the autoscaling rounds started by StartAutoscaler.
+func StopAutoscaler() {
+	if scaler != nil {
+		close(scaler.stop)
+	}
+}
+
+// targetContainers returns the containers needed for the expected arrivals of
+// a function, given the invocations each container can serve concurrently and
+// the containers to keep warm anyway.
+func (s *autoscaler) targetContainers(ff *FunctionForecast, perContainer int, minWarm int64) int64 {
+	// the invocations in flight are Poisson-distributed, with mean given by
+	// Little's law: provision for two standard deviations more, and some
+	// headroom
+	concurrency := ff.Forecast * ff.Duration
+	if concurrency < autoscalerMinConcurrency {
+		concurrency = 0.0
+	}
+	concurrency = concurrency*(1.0+s.headroom) + 2.0*math.Sqrt(concurrency)
+	target := int64(math.Ceil(concurrency / float64(perContainer)))
+	if target < minWarm {
+		target = minWarm
+	}
+	return target
+}
+
+// Autoscale updates the forecasts with the invocations served since the last
+// round, and pre-warms or destroys idle containers so that each function has
+// enough containers for the expected arrivals (within the free resources of
+// the node). Functions expected to be invoked rarely are left to keep-alive.
+func Autoscale() {
+	s := scaler
+	if s == nil {
+		return
+	}
+
+	type scaleUp struct {
+		fun   *function.Function
+		count int64
+		ff    *FunctionForecast
+	}
+	toPrewarm := make([]scaleUp, 0)
+
+	idleSince := clock.Now().Add(-s.interval).UnixNano()
+
+	s.Lock()
+	for _, fp := range functionPools() {
+		fp.Lock()
+		Resources.Lock()
+		ff, ok := s.functions[fp.fun.Name]
+		if !ok {
+			ff = &FunctionForecast{lastInvocations: fp.invocations}
+			s.functions[fp.fun.Name] = ff
+		}
+		ff.observe(float64(fp.invocations-ff.lastInvocations)/s.interval.Seconds(), s.alpha, s.beta)
+		ff.lastInvocations = fp.invocations
+		ff.Duration = fp.duration
+		ff.Target = s.targetContainers(ff, maxInFlight(fp.fun), fp.fun.MinWarm)
+		ff.Containers = int64(fp.size())
+
+		scaledDown := make([]container.ContainerID, 0)
+		if ff.Target > ff.Containers {
+			toPrewarm = append(toPrewarm, scaleUp{fp.fun, ff.Target - ff.Containers, ff})
+		} else if ff.Target > 0 {
+			// only destroy containers left unused for the whole interval
+			elem := fp.ready.Front()
+			for elem != nil && ff.Containers > ff.Target {
+				next := elem.Next()
+				if elem.Value.(warmContainer).lastUsed < idleSince {
+					warmed := fp.removeReadyContainer(elem)
+					scaledDown = append(scaledDown, warmed.contID)
+					ff.Containers--
+				}
+				elem = next
+			}
+			ff.ScaledDown += int64(len(scaledDown))
+		}
+		Resources.Unlock()
+		fp.Unlock()
+
+		if len(scaledDown) > 0 {
+			log.Printf("Autoscaler: destroying %d idle containers of %s\n", len(scaledDown), fp.fun)
+			destroyContainers(scaledDown)
+		}
+	}
+	s.Unlock()
+
+	// containers are created without holding the lock
+	for _, up := range toPrewarm {
+		var spawned int64 = 0
+		for spawned < up.count {
+			if err := newReadyContainer(up.fun, 0, false); err != nil {
+				if !errors.Is(err, OutOfResourcesErr) {
+					log.Printf("Autoscaler: pre-warming %s failed: %v\n", up.fun, err)
+				}
+				break
+			}
+			spawned++
+		}
+		if spawned > 0 {
+			log.Printf("Autoscaler: pre-warmed %d containers of %s\n", spawned, up.fun)
+		}
+
+		s.Lock()
+		up.ff.Prewarmed += spawned
+		up.ff.Containers += spawned
+		s.Unlock()
+	}
+}
+
+// AutoscalerForecasts returns a copy of the state of the autoscaler for each
+// function.
+func AutoscalerForecasts() (map[string]FunctionForecast, error) {
+	s := scaler
+	if s == nil {
+		return nil, AutoscalerDisabledErr
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	forecasts := make(map[string]FunctionForecast, len(s.functions))
+	for name, ff := range s.functions {
+		forecasts[name] = *ff
+	}
+	return forecasts, nil
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestForecastObserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		rates    []float64
+		forecast float64
+		trend    float64
+	}{
+		{"first observation", []float64{10}, 10, 0},
+		{"constant rate", []float64{4, 4, 4, 4}, 4, 0},
+		{"increasing rate", []float64{10, 20}, 16.5, 1.5},
+		{"decreasing rate", []float64{10, 20, 0}, 7.275, -0.975},
+		{"never negative", []float64{10, 0, 0}, 0, -2.025},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ff := &FunctionForecast{}
+			for _, rate := range tt.rates {
+				ff.observe(rate, 0.5, 0.3)
+			}
+			if math.Abs(ff.Forecast-tt.forecast) > 1e-9 || math.Abs(ff.Trend-tt.trend) > 1e-9 {
+				t.Errorf("got forecast %v (trend %v), want %v (trend %v)", ff.Forecast, ff.Trend, tt.forecast, tt.trend)
+			}
+			if ff.ArrivalRate != tt.rates[len(tt.rates)-1] {
+				t.Errorf("got arrival rate %v, want the last one", ff.ArrivalRate)
+			}
+		})
+	}
+}
+
+func TestTargetContainers(t *testing.T) {
+	s := &autoscaler{headroom: 0.2}
+	tests := []struct {
+		name         string
+		forecast     float64
+		duration     float64
+		perContainer int
+		minWarm      int64
+		target       int64
+	}{
+		{"no arrivals", 0, 1, 1, 0, 0},
+		{"negligible arrivals", 0.01, 1, 1, 0, 0},
+		{"one in flight", 1, 1, 1, 0, 4},               // 1.2 + 2
+		{"several in flight", 10, 0.5, 1, 0, 11},       // 6 + 2 sqrt(5)
+		{"shared containers", 10, 0.5, 4, 0, 3},        // 10.47 / 4
+		{"min warm", 0, 1, 1, 2, 2},                    // nothing expected
+		{"above min warm", 10, 0.5, 1, 2, 11},          // more than MinWarm needed
+		{"negligible with min warm", 0.01, 1, 1, 1, 1}, // MinWarm only
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ff := &FunctionForecast{Forecast: tt.forecast, Duration: tt.duration}
+			if target := s.targetContainers(ff, tt.perContainer, tt.minWarm); target != tt.target {
+				t.Errorf("got %d, want %d", target, tt.target)
+			}
+		})
+	}
+}
+
+func TestStopAutoscaler(t *testing.T) {
+	old := scaler
+	defer func() { scaler = old }()
+
+	// rounds not started (e.g., by the simulator): stopping must not block
+	scaler = &autoscaler{interval: time.Hour, stop: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		StopAutoscaler()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StopAutoscaler blocked")
+	}
+}
@@ -0,0 +1,155 @@
+package node
+
+import (
+	"container/list"
+	"log"
+	"math"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+)
+
+// estimateAlpha is the smoothing factor of the cold start time and duration
+// estimates
+const estimateAlpha = 0.3
+
+// recentHalfLife is how long it takes for an invocation to count half as much
+// in the invocation frequency of a function
+const recentHalfLife = 10 * time.Minute
+
+// evictionPolicy chooses the ready containers to destroy when memory is
+// needed for a new container.
+// The policies are used with Resources locked.
+type evictionPolicy interface {
+	// onReady is called when a container becomes ready.
+	onReady(c *warmContainer)
+	// priority returns the priority of a ready container of a pool: the
+	// containers with the lowest priority are evicted first.
+	priority(fp *ContainerPool, c *warmContainer) float64
+	// onEvicted is called when a container with the given priority is
+	// evicted.
+	onEvicted(priority float64)
+}
+
+// selectionPolicy chooses the ready container to serve an invocation.
+type selectionPolicy func(ready *list.List) *list.Element
+
+var eviction evictionPolicy = &firstFoundEviction{}
+var selectReady selectionPolicy = selectOldest
+
+// initPoolPolicies sets the eviction, selection, keep-alive and health check
+// policies from the configuration.
+func initPoolPolicies() {
+	adaptiveKeepAlive = config.GetBool(config.CONTAINER_KEEPALIVE_ADAPTIVE, false)
+	periodicHealthChecks = config.GetBool(config.CONTAINER_HEALTHCHECK, true)
+	healthCheckOnAcquire = config.GetBool(config.CONTAINER_HEALTHCHECK_ON_ACQUIRE, false)
+	healthCheckGrace = time.Duration(config.GetInt(config.CONTAINER_HEALTHCHECK_GRACE, 30)) * time.Second
+
+	switch policy := config.GetString(config.CONTAINER_EVICTION_POLICY, "default"); policy {
+	case "lru":
+		eviction = &lruEviction{}
+	case "lfu":
+		eviction = &lfuEviction{}
+	case "greedydual":
+		eviction = &greedyDualEviction{}
+	default:
+		if policy != "default" {
+			log.Printf("Unknown eviction policy: %s\n", policy)
+		}
+		eviction = &firstFoundEviction{}
+	}
+
+	switch policy := config.GetString(config.CONTAINER_SELECTION_POLICY, "fifo"); policy {
+	case "mru":
+		selectReady = selectMostRecent
+	default:
+		if policy != "fifo" {
+			log.Printf("Unknown selection policy: %s\n", policy)
+		}
+		selectReady = selectOldest
+	}
+}
+
+// selectOldest picks the container that has been ready for the longest time.
+func selectOldest(ready *list.List) *list.Element {
+	return ready.Front()
+}
+
+// selectMostRecent picks the container that has been used most recently, so
+// that spare containers are left idle (and eventually expire).
+func selectMostRecent(ready *list.List) *list.Element {
+	return ready.Back()
+}
+
+// firstFoundEviction evicts the first containers found, regardless of their
+// usage.
+type firstFoundEviction struct{}
+
+func (p *firstFoundEviction) onReady(_ *warmContainer) {}
+
+func (p *firstFoundEviction) priority(_ *ContainerPool, _ *warmContainer) float64 {
+	return 0.0
+}
+
+func (p *firstFoundEviction) onEvicted(_ float64) {}
+
+// lruEviction evicts the least recently used containers first.
+type lruEviction struct{}
+
+func (p *lruEviction) onReady(_ *warmContainer) {}
+
+func (p *lruEviction) priority(_ *ContainerPool, c *warmContainer) float64 {
+	return float64(c.lastUsed)
+}
+
+func (p *lruEviction) onEvicted(_ float64) {}
+
+// recentInvocations returns the invocations of the pool, each one weighted by
+// how recent it is (halving every recentHalfLife), so that the frequency of
+// invocations follows the current traffic.
+// The function is NOT thread-safe.
+func (fp *ContainerPool) recentInvocations(now int64) float64 {
+	if fp.recent == 0.0 {
+		return 0.0
+	}
+	return fp.recent * math.Exp2(-float64(now-fp.recentUpdated)/float64(recentHalfLife))
+}
+
+// lfuEviction evicts the containers of the least frequently invoked functions
+// (lately) first.
+type lfuEviction struct{}
+
+func (p *lfuEviction) onReady(_ *warmContainer) {}
+
+func (p *lfuEviction) priority(fp *ContainerPool, _ *warmContainer) float64 {
+	return fp.recentInvocations(clock.Now().UnixNano())
+}
+
+func (p *lfuEviction) onEvicted(_ float64) {}
+
+// greedyDualEviction implements Greedy-Dual-Size-Frequency: the priority of a
+// container is cold start time x invocations (lately) / memory, plus an
+// inflation value, which grows with evictions, so that containers idle for
+// long are eventually evicted even if their function used to be invoked
+// frequently.
+type greedyDualEviction struct {
+	inflation float64
+}
+
+func (p *greedyDualEviction) onReady(c *warmContainer) {
+	c.inflation = p.inflation
+}
+
+func (p *greedyDualEviction) priority(fp *ContainerPool, c *warmContainer) float64 {
+	if c.memMB <= 0 {
+		return c.inflation
+	}
+	return c.inflation + fp.coldStartTime*fp.recentInvocations(clock.Now().UnixNano())/float64(c.memMB)
+}
+
+func (p *greedyDualEviction) onEvicted(priority float64) {
+	if priority > p.inflation {
+		p.inflation = priority
+	}
+}
@@ -0,0 +1,124 @@
+package node
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestPool returns a pool whose function has been invoked at the given
+// times (since the start of the test clock).
+func newTestPool(c *testClock, name string, invocations ...time.Duration) *ContainerPool {
+	fp := newFunctionPool(newTestFunction(name, 128, 1))
+	start := c.Now()
+	for _, at := range invocations {
+		c.now = start.Add(at)
+		fp.recordInvocation()
+	}
+	c.now = start
+	return fp
+}
+
+func TestRecentInvocations(t *testing.T) {
+	_, c := setupTest(t, 1, 1024)
+	tests := []struct {
+		name        string
+		invocations []time.Duration
+		at          time.Duration
+		want        float64
+	}{
+		{"never invoked", nil, 0, 0},
+		{"just invoked", []time.Duration{0, 0}, 0, 2},
+		{"half-life", []time.Duration{0, 0}, recentHalfLife, 1},
+		{"decayed between invocations", []time.Duration{0, recentHalfLife}, recentHalfLife, 1.5},
+		{"long ago", []time.Duration{0, 0, 0, 0}, 10 * recentHalfLife, 4.0 / 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := newTestPool(c, "f", tt.invocations...)
+			got := fp.recentInvocations(c.Now().Add(tt.at).UnixNano())
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictionPriority(t *testing.T) {
+	_, c := setupTest(t, 1, 1024)
+	now := c.Now()
+
+	// once hot, and invoked now and then lately
+	var burst []time.Duration
+	for i := 0; i < 1000; i++ {
+		burst = append(burst, 0)
+	}
+	hot := newTestPool(c, "hot", burst...)
+	steady := newTestPool(c, "steady", 13*recentHalfLife, 14*recentHalfLife, 15*recentHalfLife, 16*recentHalfLife)
+	c.now = now.Add(16 * recentHalfLife)
+
+	old := &warmContainer{memMB: 128, lastUsed: now.UnixNano()}
+	recent := &warmContainer{memMB: 128, lastUsed: now.Add(time.Minute).UnixNano()}
+	large := &warmContainer{memMB: 1024, lastUsed: now.UnixNano()}
+
+	type candidate struct {
+		name string
+		fp   *ContainerPool
+		c    *warmContainer
+	}
+	tests := []struct {
+		policy evictionPolicy
+		first  candidate // evicted first
+		second candidate
+	}{
+		{&lruEviction{}, candidate{"old", steady, old}, candidate{"recent", steady, recent}},
+		{&lfuEviction{}, candidate{"hot long ago", hot, recent}, candidate{"steady", steady, old}},
+		{&greedyDualEviction{}, candidate{"hot long ago", hot, recent}, candidate{"steady", steady, old}},
+		{&greedyDualEviction{}, candidate{"large", steady, large}, candidate{"small", steady, old}},
+	}
+	for _, tt := range tests {
+		hot.coldStartTime, steady.coldStartTime = 1.0, 1.0
+		for _, cand := range []candidate{tt.first, tt.second} {
+			tt.policy.onReady(cand.c)
+		}
+		first := tt.policy.priority(tt.first.fp, tt.first.c)
+		second := tt.policy.priority(tt.second.fp, tt.second.c)
+		if first >= second {
+			t.Errorf("%T: %s (%v) not evicted before %s (%v)", tt.policy, tt.first.name, first, tt.second.name, second)
+		}
+	}
+
+	// all the same to the default policy
+	p := &firstFoundEviction{}
+	if p.priority(hot, old) != p.priority(steady, recent) {
+		t.Errorf("firstFoundEviction: priorities differ")
+	}
+}
+
+func TestGreedyDualInflation(t *testing.T) {
+	_, c := setupTest(t, 1, 1024)
+	frequent := newTestPool(c, "frequent", 0, 0, 0, 0)
+	rare := newTestPool(c, "rare", 0)
+	frequent.coldStartTime, rare.coldStartTime = 1.0, 1.0
+
+	p := &greedyDualEviction{}
+	idle := &warmContainer{memMB: 1}
+	p.onReady(idle)
+	if p.priority(frequent, idle) != 4 {
+		t.Fatalf("got priority %v, want 4", p.priority(frequent, idle))
+	}
+
+	// after an eviction, a new container of a rare function outranks a
+	// container of a frequent one idle since before
+	p.onEvicted(4)
+	fresh := &warmContainer{memMB: 1}
+	p.onReady(fresh)
+	if p.priority(rare, fresh) <= p.priority(frequent, idle) {
+		t.Errorf("got %v for the new container, %v for the idle one", p.priority(rare, fresh), p.priority(frequent, idle))
+	}
+	// the inflation never decreases
+	p.onEvicted(1)
+	if p.inflation != 4 {
+		t.Errorf("got inflation %v, want 4", p.inflation)
+	}
+}
@@ -0,0 +1,110 @@
+package node
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// periodicHealthChecks is whether the janitor checks the liveness of the
+// ready containers
+var periodicHealthChecks bool
+
+// healthCheckOnAcquire is whether the liveness of warm containers is checked
+// before serving an invocation
+var healthCheckOnAcquire bool
+
+// healthCheckGrace is how long after becoming ready a container is not
+// checked (e.g., a pre-warmed container whose Executor is still starting)
+var healthCheckGrace time.Duration
+
+// healthCheckParallelism is the max number of containers checked at a time
+// by the janitor
+const healthCheckParallelism = 8
+
+// CheckWarmContainers destroys the ready containers found dead (e.g., crashed
+// or killed externally), releasing their resources. Containers ready since
+// less than the grace period are not checked. Containers kept at all
+// times (MinWarm) are replaced by DeleteExpiredContainer.
+// It is called by the container cleaner, if health checks are enabled.
+func CheckWarmContainers() {
+	if !periodicHealthChecks {
+		return
+	}
+
+	readyBefore := clock.Now().Add(-healthCheckGrace).UnixNano()
+	slots := make(chan struct{}, healthCheckParallelism)
+	var wg sync.WaitGroup
+	for _, fp := range functionPools() {
+		fp.Lock()
+		contIDs := make([]container.ContainerID, 0, fp.ready.Len())
+		for elem := fp.ready.Front(); elem != nil; elem = elem.Next() {
+			if warmed := elem.Value.(warmContainer); warmed.lastUsed < readyBefore {
+				contIDs = append(contIDs, warmed.contID)
+			}
+		}
+		fp.Unlock()
+
+		// containers are checked without holding the lock, as it may take a
+		// while, and several at a time
+		for _, contID := range contIDs {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(fp *ContainerPool, contID container.ContainerID) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				if err := container.CheckHealth(contID); err != nil {
+					log.Printf("cleaner: Removing dead container %s: %v\n", contID, err)
+					removeDeadContainer(fp, contID)
+				}
+			}(fp, contID)
+		}
+	}
+	wg.Wait()
+}
+
+// removeDeadContainer removes a container from the ready list (unless
+// acquired in the meantime), releasing its resources, and destroys it.
+func removeDeadContainer(fp *ContainerPool, contID container.ContainerID) {
+	fp.Lock()
+	Resources.Lock()
+	found := false
+	for elem := fp.ready.Front(); elem != nil; elem = elem.Next() {
+		if elem.Value.(warmContainer).contID == contID {
+			fp.removeReadyContainer(elem)
+			found = true
+			break
+		}
+	}
+	Resources.Unlock()
+	fp.Unlock()
+
+	if found {
+		// whatever is left of the container (e.g., if exited)
+		destroyContainers([]container.ContainerID{contID})
+	}
+}
+
+// acquireHealthyContainer acquires a warm container, discarding those found
+// dead until a healthy one is acquired (or none is left). Containers ready
+// since less than the grace period are not checked.
+func acquireHealthyContainer(f *function.Function) (container.ContainerID, error) {
+	for {
+		contID, readySince, err := acquireWarmContainer(f)
+		if err != nil || !healthCheckOnAcquire || clock.Now().UnixNano()-readySince < int64(healthCheckGrace) {
+			return contID, err
+		}
+		err = container.CheckHealth(contID)
+		if err == nil {
+			return contID, nil
+		}
+		log.Printf("Discarding dead container %s of %s: %v\n", contID, f, err)
+		DestroyContainer(contID, f)
+	}
+}
@@ -0,0 +1,92 @@
+package node
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckWarmContainers(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration // since the containers became ready
+		ready   int
+	}{
+		{"young containers not checked", 10 * time.Second, 3},
+		{"dead container removed", 2 * time.Minute, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, c := setupTest(t, 4, 1024)
+			periodicHealthChecks = true
+			healthCheckGrace = time.Minute
+			f := newTestFunction("health", 128, 1)
+			for i := 0; i < 3; i++ {
+				if err := newReadyContainer(f, 0, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cf.Kill(readyContainers(f)[0])
+
+			c.advance(tt.elapsed)
+			CheckWarmContainers()
+
+			if ready := len(readyContainers(f)); ready != tt.ready {
+				t.Errorf("got %d ready containers, want %d", ready, tt.ready)
+			}
+			fp := lockFunctionPool(f)
+			containers, warm := fp.containers, fp.warm
+			fp.Unlock()
+			if containers != tt.ready || warm != tt.ready {
+				t.Errorf("got %d containers (%d warm), want %d", containers, warm, tt.ready)
+			}
+			if mem := Resources.AvailableMemMB; mem != 1024-int64(tt.ready)*128 {
+				t.Errorf("got %d MB available, want %d", mem, 1024-int64(tt.ready)*128)
+			}
+		})
+	}
+}
+
+func TestAcquireHealthyContainer(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration // since the containers became ready
+		dead    int           // the oldest ones
+		want    int           // index of the container acquired, -1 if none
+		memMB   int64         // available afterwards
+	}{
+		{"young dead container not checked", 10 * time.Second, 1, 0, 1024 - 2*128},
+		{"dead container discarded", 2 * time.Minute, 1, 1, 1024 - 128},
+		{"all dead", 2 * time.Minute, 2, -1, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, c := setupTest(t, 4, 1024)
+			healthCheckOnAcquire = true
+			healthCheckGrace = time.Minute
+			f := newTestFunction("health", 128, 1)
+			for i := 0; i < 2; i++ {
+				if err := newReadyContainer(f, 0, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ready := readyContainers(f)
+			for _, contID := range ready[:tt.dead] {
+				cf.Kill(contID)
+			}
+
+			c.advance(tt.elapsed)
+			contID, err := AcquireWarmContainer(f)
+			if tt.want < 0 {
+				if !errors.Is(err, NoWarmFoundErr) {
+					t.Fatalf("got %s (%v), want no container", contID, err)
+				}
+			} else if err != nil || contID != ready[tt.want] {
+				t.Fatalf("got %s (%v), want %s", contID, err, ready[tt.want])
+			}
+			if mem := Resources.AvailableMemMB; mem != tt.memMB {
+				t.Errorf("got %d MB available, want %d", mem, tt.memMB)
+			}
+		})
+	}
+}
@@ -35,6 +35,7 @@ func (j *janitor) run() {
 	for {
 		select {
 		case <-ticker.C:
+			CheckWarmContainers()
 			DeleteExpiredContainer()
 		case <-j.stop:
 			ticker.Stop()
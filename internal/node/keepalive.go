@@ -0,0 +1,158 @@
+package node
+
+import (
+	"log"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// The adaptive keep-alive follows the hybrid histogram policy (Shahrad et al.,
+// "Serverless in the Wild", ATC'20): the inter-arrival times of the
+// invocations of each function are collected in a histogram, and containers
+// are kept warm up to the tail of the distribution. If the next invocation is
+// not expected before the head of the distribution, the container is unloaded
+// and pre-warmed right before then. The counts are halved periodically, so
+// that the distribution follows changes in the traffic.
+const (
+	histogramBinWidth  = time.Second
+	histogramBins      = 3600 // inter-arrival times up to 1 hour
+	histogramMinSample = 10
+	histogramHead      = 0.05
+	histogramTail      = 0.99
+	histogramMargin    = 0.1
+	histogramHalfLife  = time.Hour
+)
+
+// adaptiveKeepAlive is whether the keep-alive of functions without their own
+// is learned from their inter-arrival times
+var adaptiveKeepAlive bool
+
+// arrivalHistogram is the distribution of the inter-arrival times of a
+// function.
+type arrivalHistogram struct {
+	bins        [histogramBins]int64
+	outOfRange  int64
+	samples     int64
+	lastArrival int64 // UnixNano (0 if none)
+	lastAged    int64 // UnixNano
+}
+
+// observe records an arrival.
+func (h *arrivalHistogram) observe(now int64) {
+	h.age(now)
+	if h.lastArrival > 0 {
+		bin := time.Duration(now-h.lastArrival) / histogramBinWidth
+		if bin < histogramBins {
+			h.bins[bin]++
+		} else {
+			h.outOfRange++
+		}
+		h.samples++
+	}
+	h.lastArrival = now
+}
+
+// age halves the counts once for each histogramHalfLife elapsed.
+func (h *arrivalHistogram) age(now int64) {
+	if h.lastAged == 0 {
+		h.lastAged = now
+		return
+	}
+	periods := (now - h.lastAged) / int64(histogramHalfLife)
+	if periods <= 0 {
+		return
+	}
+	h.lastAged += periods * int64(histogramHalfLife)
+	if periods > 62 {
+		periods = 62
+	}
+
+	h.outOfRange >>= periods
+	h.samples = h.outOfRange
+	for bin := range h.bins {
+		h.bins[bin] >>= periods
+		h.samples += h.bins[bin]
+	}
+}
+
+// percentile returns the given percentile (0-1) of the inter-arrival times,
+// rounded up to the bin width. It fails if there are too few samples, or the
+// percentile is out of the histogram range.
+func (h *arrivalHistogram) percentile(p float64) (time.Duration, bool) {
+	if h.samples < histogramMinSample {
+		return 0, false
+	}
+	threshold := int64(p * float64(h.samples))
+	var count int64 = 0
+	for bin, n := range h.bins {
+		count += n
+		if count > threshold {
+			return time.Duration(bin+1) * histogramBinWidth, true
+		}
+	}
+	return 0, false
+}
+
+// recordInvocation updates the statistics of the pool with a new invocation.
+// The function is NOT thread-safe.
+func (fp *ContainerPool) recordInvocation() {
+	now := clock.Now().UnixNano()
+	fp.invocations++
+	fp.recent = fp.recentInvocations(now) + 1.0
+	fp.recentUpdated = now
+	if !adaptiveKeepAlive {
+		return
+	}
+	if fp.arrivals == nil {
+		fp.arrivals = &arrivalHistogram{}
+	}
+	fp.arrivals.observe(now)
+}
+
+// keepAlive returns how long an idle container of the pool is kept warm and,
+// if positive, after how long it should be pre-warmed instead, if unloaded
+// right away.
+// The function is NOT thread-safe.
+func (fp *ContainerPool) keepAlive(f *function.Function) (time.Duration, time.Duration) {
+	if f.KeepAlive > 0.0 {
+		return time.Duration(f.KeepAlive * float64(time.Second)), 0
+	}
+	defaultKeepAlive := time.Duration(config.GetInt(config.CONTAINER_EXPIRATION_TIME, 600)) * time.Second
+	if !adaptiveKeepAlive || fp.arrivals == nil {
+		return defaultKeepAlive, 0
+	}
+
+	tail, ok := fp.arrivals.percentile(histogramTail)
+	if !ok {
+		return defaultKeepAlive, 0
+	}
+	keepAlive := time.Duration(float64(tail) * (1.0 + histogramMargin))
+
+	head, _ := fp.arrivals.percentile(histogramHead)
+	coldStart := time.Duration(fp.coldStartTime * float64(time.Second))
+	prewarmAfter := time.Duration(float64(head)*(1.0-histogramMargin)) - coldStart
+	if fp.coldStartTime <= 0.0 || prewarmAfter <= 0 {
+		return keepAlive, 0
+	}
+	return keepAlive, prewarmAfter
+}
+
+// schedulePrewarm creates a warm container for a function after the given
+// delay, unless a container is available by then.
+func schedulePrewarm(f *function.Function, after time.Duration, keepAlive time.Duration) {
+	clock.AfterFunc(after, func() {
+		Resources.RLock()
+		fp, ok := Resources.ContainerPools[f.Name]
+		idle := ok && fp.warm == 0 && fp.starting == 0 && fp.inFlight == 0
+		Resources.RUnlock()
+		if !idle {
+			return
+		}
+		if err := newReadyContainer(f, keepAlive, false); err != nil {
+			log.Printf("Pre-warming a container for %s failed: %v\n", f, err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestHistogram returns a histogram with the given number of samples in
+// each bin, plus some out of range.
+func newTestHistogram(bins map[int]int64, outOfRange int64) *arrivalHistogram {
+	h := &arrivalHistogram{outOfRange: outOfRange, samples: outOfRange}
+	for bin, n := range bins {
+		h.bins[bin] = n
+		h.samples += n
+	}
+	return h
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name       string
+		bins       map[int]int64
+		outOfRange int64
+		p          float64
+		want       time.Duration
+		ok         bool
+	}{
+		{"too few samples", map[int]int64{0: histogramMinSample - 1}, 0, 0.5, 0, false},
+		{"head", map[int]int64{0: 5, 9: 90, 99: 5}, 0, histogramHead, 10 * time.Second, true},
+		{"head within the first bin", map[int]int64{0: 6, 9: 89, 99: 5}, 0, histogramHead, time.Second, true},
+		{"tail", map[int]int64{0: 5, 9: 90, 99: 5}, 0, histogramTail, 100 * time.Second, true},
+		{"tail out of range", map[int]int64{0: 5, 9: 90}, 5, histogramTail, 0, false},
+		{"head with samples out of range", map[int]int64{0: 5, 9: 90}, 5, histogramHead, 10 * time.Second, true},
+		{"last bin", map[int]int64{histogramBins - 1: 10}, 0, histogramTail, histogramBins * histogramBinWidth, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHistogram(tt.bins, tt.outOfRange)
+			got, ok := h.percentile(tt.p)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got (%v, %v), want (%v, %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHistogramObserve(t *testing.T) {
+	start := time.Unix(1000, 0)
+	h := &arrivalHistogram{}
+	for _, at := range []time.Duration{0, 1500 * time.Millisecond, 3 * time.Second, 90 * time.Minute} {
+		h.observe(start.Add(at).UnixNano())
+	}
+	// 1.5 s, 1.5 s and out of range, halved once (after the first hour)
+	if h.bins[1] != 1 || h.outOfRange != 1 || h.samples != 2 {
+		t.Errorf("got %d in bin 1, %d out of range (%d samples)", h.bins[1], h.outOfRange, h.samples)
+	}
+}
+
+func TestHistogramAging(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    int64
+	}{
+		{"within the half-life", histogramHalfLife - time.Second, 40},
+		{"half-life", histogramHalfLife, 20},
+		{"two half-lives", 2*histogramHalfLife + time.Minute, 10},
+		{"forgotten", 100 * histogramHalfLife, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHistogram(map[int]int64{9: 40}, 0)
+			h.lastAged = time.Unix(1000, 0).UnixNano()
+			h.age(time.Unix(1000, 0).Add(tt.elapsed).UnixNano())
+			if h.bins[9] != tt.want || h.samples != tt.want {
+				t.Errorf("got %d (%d samples), want %d", h.bins[9], h.samples, tt.want)
+			}
+		})
+	}
+
+	// the old inter-arrival times no longer outweigh the new ones
+	h := &arrivalHistogram{}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		now = now.Add(10 * time.Second)
+		h.observe(now.UnixNano())
+	}
+	now = now.Add(2 * histogramHalfLife)
+	for i := 0; i < 50; i++ {
+		now = now.Add(time.Minute)
+		h.observe(now.UnixNano())
+	}
+	if median, _ := h.percentile(0.5); median != time.Minute+time.Second {
+		t.Errorf("got median %v, want the new inter-arrival time", median)
+	}
+}
+
+// enableAdaptiveKeepAlive sets the adaptive keep-alive for a test (after
+// setupTest, which resets it from the configuration).
+func enableAdaptiveKeepAlive(t *testing.T, enabled bool) {
+	adaptiveKeepAlive = enabled
+	t.Cleanup(func() { adaptiveKeepAlive = false })
+}
+
+func TestKeepAlive(t *testing.T) {
+	regular := map[int]int64{59: 100} // every minute
+	tests := []struct {
+		name         string
+		adaptive     bool
+		fixed        float64 // KeepAlive of the function (s)
+		bins         map[int]int64
+		coldStart    float64
+		keepAlive    time.Duration
+		prewarmAfter time.Duration
+	}{
+		{"default", false, 0, regular, 1, 600 * time.Second, 0},
+		{"set for the function", true, 30, regular, 1, 30 * time.Second, 0},
+		{"too few samples", true, 0, map[int]int64{59: 5}, 1, 600 * time.Second, 0},
+		{"tail out of range", true, 0, map[int]int64{59: 50, histogramBins - 1: 50}, 1, 3960 * time.Second, 53 * time.Second},
+		{"unloaded and pre-warmed", true, 0, regular, 1, 66 * time.Second, 53 * time.Second},
+		{"unknown cold start time", true, 0, regular, 0, 66 * time.Second, 0},
+		{"frequent invocations", true, 0, map[int]int64{0: 100}, 1, 1100 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, 1, 1024)
+			enableAdaptiveKeepAlive(t, tt.adaptive)
+			f := newTestFunction("keepalive", 128, 1)
+			f.KeepAlive = tt.fixed
+			fp := newFunctionPool(f)
+			fp.arrivals = newTestHistogram(tt.bins, 0)
+			fp.coldStartTime = tt.coldStart
+
+			keepAlive, prewarmAfter := fp.keepAlive(f)
+			if keepAlive != tt.keepAlive || prewarmAfter != tt.prewarmAfter {
+				t.Errorf("got (%v, %v), want (%v, %v)", keepAlive, prewarmAfter, tt.keepAlive, tt.prewarmAfter)
+			}
+		})
+	}
+}
+
+func TestKeepAlivePrewarm(t *testing.T) {
+	_, c := setupTest(t, 1, 1024)
+	enableAdaptiveKeepAlive(t, true)
+	f := newTestFunction("prewarm", 128, 1)
+
+	contID, err := NewContainer(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := lockFunctionPool(f)
+	fp.arrivals = newTestHistogram(map[int]int64{59: 100}, 0)
+	fp.coldStartTime = 1.0
+	fp.Unlock()
+
+	// no invocation expected for a while: unloaded until then
+	ReleaseContainer(contID, f)
+	if len(readyContainers(f)) != 0 || Resources.AvailableMemMB != 1024 {
+		t.Errorf("container kept warm (%d MB available)", Resources.AvailableMemMB)
+	}
+	if len(c.scheduled) != 1 || c.scheduled[0] != 53*time.Second {
+		t.Errorf("got pre-warming scheduled after %v, want 53s", c.scheduled)
+	}
+	checkCounters(t, 1, 1024)
+}
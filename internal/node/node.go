@@ -10,6 +10,10 @@ var OutOfResourcesErr = errors.New("not enough resources for function execution"
 
 var NodeIdentifier string
 
+// NodeAddress is the URL of the node API, which also identifies the
+// containers left by previous runs of the node
+var NodeAddress string
+
 type NodeResources struct {
 	sync.RWMutex
 	AvailableMemMB int64
@@ -23,3 +27,14 @@ func (n *NodeResources) String() string {
 }
 
 var Resources NodeResources
+
+// InitResources sets the resources available on the node, with no containers.
+func InitResources(cpus float64, memMB int64) {
+	Resources.Lock()
+	defer Resources.Unlock()
+	Resources.AvailableCPUs = cpus
+	Resources.AvailableMemMB = memMB
+	Resources.DropCount = 0
+	Resources.ContainerPools = make(map[string]*ContainerPool)
+	initPoolPolicies()
+}
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"log"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// AdoptOrphanedContainers looks for the containers left by previous runs of
+// the node (e.g., if it crashed), i.e., those created with the same address
+// but a different node identifier. Running containers whose function has not
+// changed in the meantime are adopted back into the warm pools, as long as
+// resources are available; the others are destroyed.
+// It is called on startup, before serving any request.
+func AdoptOrphanedContainers() {
+	if NodeAddress == "" {
+		return
+	}
+	containers, err := container.List(container.AddressLabel, NodeAddress)
+	if err != nil {
+		log.Printf("Could not look for orphaned containers: %v\n", err)
+		return
+	}
+
+	adopted := 0
+	orphans := make([]container.ContainerID, 0)
+	for _, c := range containers {
+		if c.Labels[container.NodeLabel] == NodeIdentifier {
+			continue
+		}
+		if adoptContainer(c) {
+			adopted++
+		} else {
+			orphans = append(orphans, c.ID)
+		}
+	}
+	if adopted > 0 || len(orphans) > 0 {
+		log.Printf("Adopted %d containers left by a previous run, destroying %d orphans\n", adopted, len(orphans))
+	}
+	destroyContainers(orphans)
+}
+
+// adoptContainer adds a container left by a previous run to the ready
+// containers of its function, if it can still serve invocations.
+func adoptContainer(c container.ContainerInfo) bool {
+	if !c.Running {
+		return false
+	}
+	f, ok := function.GetFunction(c.Labels[container.FunctionLabel])
+	if !ok || c.Labels[container.CodeHashLabel] != f.CodeHash() {
+		return false // the function has been deleted or updated
+	}
+	memMB, err := container.GetMemoryMB(c.ID)
+	if err != nil || memMB != f.MemoryMB {
+		return false
+	}
+
+	now := clock.Now()
+	fp := lockFunctionPool(f)
+	defer fp.Unlock()
+	Resources.Lock()
+	defer Resources.Unlock()
+
+	if f.MaxConcurrency > 0 && int64(fp.size()) >= f.MaxConcurrency {
+		return false
+	}
+	if !acquireResources(fp, f, 0, memMB, false) {
+		return false
+	}
+	fp.containers++
+	keepAlive, _ := fp.keepAlive(f)
+	fp.putReadyContainer(c.ID, memMB, now.UnixNano(), now.Add(keepAlive).UnixNano())
+	return true
+}
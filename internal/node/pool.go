@@ -5,28 +5,66 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/clock"
 	"github.com/grussorusso/serverledge/internal/container"
 	"github.com/grussorusso/serverledge/internal/function"
 )
 
+// ContainerPool holds the containers of a function.
+// The lists of containers are protected by the lock of the pool, while the
+// counters (needed to account for the resources of all the functions) are
+// protected by the lock of Resources. The lock of the pool is always acquired
+// first.
 type ContainerPool struct {
-	busy  *list.List // list of ContainerID
+	sync.Mutex
+	busy  *list.List // list of *busyContainer
 	ready *list.List // list of warmContainer
+
+	// protected by Resources
+	fun           *function.Function
+	starting      int     // containers being created
+	containers    int     // busy or ready containers
+	warm          int     // ready containers
+	inFlight      int     // invocations being served
+	invocations   int64   // invocations served so far
+	recent        float64 // invocations, decayed as of recentUpdated (see recentInvocations)
+	recentUpdated int64
+	coldStartTime float64 // estimated (s)
+	duration      float64 // estimated (s)
+	arrivals      *arrivalHistogram
 }
 
 type warmContainer struct {
 	Expiration int64
 	contID     container.ContainerID
+	memMB      int64
+	lastUsed   int64   // when the container became ready
+	inflation  float64 // set by the eviction policy
+}
+
+// busyContainer is a container serving at least one invocation.
+type busyContainer struct {
+	contID    container.ContainerID
+	memMB     int64
+	inFlight  int  // invocations being served
+	discarded bool // destroy as soon as no invocation is in flight
 }
 
 var NoWarmFoundErr = errors.New("no warm container is available")
 
+// ConcurrencyLimitErr is returned when a function has as many containers as
+// its max concurrency
+var ConcurrencyLimitErr = fmt.Errorf("%w: max concurrency reached", OutOfResourcesErr)
+
 // getFunctionPool retrieves (or creates) the container pool for a function.
+// The function is NOT thread-safe.
 func getFunctionPool(f *function.Function) *ContainerPool {
 	if fp, ok := Resources.ContainerPools[f.Name]; ok {
+		fp.fun = f // the latest definition of the function
 		return fp
 	}
 
@@ -35,59 +73,264 @@ func getFunctionPool(f *function.Function) *ContainerPool {
 	return fp
 }
 
-func (fp *ContainerPool) getWarmContainer() (container.ContainerID, bool) {
-	// TODO: picking most-recent / least-recent container might be better?
-	elem := fp.ready.Front()
+// lockFunctionPool retrieves (or creates) the container pool for a function,
+// and locks it.
+func lockFunctionPool(f *function.Function) *ContainerPool {
+	Resources.RLock()
+	fp, ok := Resources.ContainerPools[f.Name]
+	upToDate := ok && fp.fun == f
+	Resources.RUnlock()
+	if !upToDate {
+		Resources.Lock()
+		fp = getFunctionPool(f)
+		Resources.Unlock()
+	}
+
+	fp.Lock()
+	return fp
+}
+
+// functionPools returns all the container pools, by function name (so that
+// simulations are reproducible).
+func functionPools() []*ContainerPool {
+	Resources.RLock()
+	defer Resources.RUnlock()
+
+	pools := make([]*ContainerPool, 0, len(Resources.ContainerPools))
+	for _, fp := range Resources.ContainerPools {
+		pools = append(pools, fp)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].fun.Name < pools[j].fun.Name })
+	return pools
+}
+
+// maxInFlight returns how many invocations a container of the function may
+// serve concurrently.
+func maxInFlight(f *function.Function) int {
+	if f.MaxConcurrencyPerContainer > 1 {
+		return int(f.MaxConcurrencyPerContainer)
+	}
+	return 1
+}
+
+// getSharableContainer returns a busy container that can serve one more
+// invocation (if any).
+func (fp *ContainerPool) getSharableContainer(maxInFlight int) *busyContainer {
+	if maxInFlight <= 1 {
+		return nil
+	}
+	for elem := fp.busy.Front(); elem != nil; elem = elem.Next() {
+		bc := elem.Value.(*busyContainer)
+		if !bc.discarded && bc.inFlight < maxInFlight {
+			return bc
+		}
+	}
+	return nil
+}
+
+// hasWarmContainer checks whether a container can serve an invocation
+// without a cold start.
+func (fp *ContainerPool) hasWarmContainer(maxInFlight int) bool {
+	return fp.ready.Len() > 0 || fp.getSharableContainer(maxInFlight) != nil
+}
+
+// getWarmContainer acquires a container for an invocation, and returns when
+// it became ready (now, for containers serving other invocations). Busy
+// containers that can serve more invocations are preferred
+// to ready ones, so that fewer containers are kept busy.
+// Resources must be locked by the caller, to update the counters.
+func (fp *ContainerPool) getWarmContainer(maxInFlight int) (container.ContainerID, int64, bool) {
+	if bc := fp.getSharableContainer(maxInFlight); bc != nil {
+		bc.inFlight++
+		fp.inFlight++
+		fp.recordInvocation()
+		return bc.contID, clock.Now().UnixNano(), true
+	}
+
+	elem := selectReady(fp.ready)
 	if elem == nil {
-		return "", false
+		return "", 0, false
 	}
 
 	fp.ready.Remove(elem)
-	contID := elem.Value.(warmContainer).contID
-	fp.putBusyContainer(contID)
+	fp.warm--
+	warmed := elem.Value.(warmContainer)
+	fp.putBusyContainer(warmed.contID, warmed.memMB)
 
-	return contID, true
+	return warmed.contID, warmed.lastUsed, true
 }
 
-func (fp *ContainerPool) putBusyContainer(contID container.ContainerID) {
-	fp.busy.PushBack(contID)
+// putBusyContainer adds a container serving an invocation to the busy list.
+// Resources must be locked by the caller, to update the counters.
+func (fp *ContainerPool) putBusyContainer(contID container.ContainerID, memMB int64) {
+	fp.busy.PushBack(&busyContainer{contID: contID, memMB: memMB, inFlight: 1})
+	fp.inFlight++
+	fp.recordInvocation()
+}
+
+// releaseBusyContainer marks the end of an invocation served by a container.
+// It returns the container if no other invocation is in flight, in which case
+// the container is removed from the busy list.
+// Resources must be locked by the caller, to update the counters.
+func (fp *ContainerPool) releaseBusyContainer(contID container.ContainerID) (*busyContainer, bool) {
+	for elem := fp.busy.Front(); elem != nil; elem = elem.Next() {
+		bc := elem.Value.(*busyContainer)
+		if bc.contID != contID {
+			continue
+		}
+		bc.inFlight--
+		fp.inFlight--
+		if bc.inFlight > 0 {
+			return bc, false
+		}
+		fp.busy.Remove(elem) // delete the element from the busy list
+		return bc, true
+	}
+	return nil, false
 }
 
-func (fp *ContainerPool) putReadyContainer(contID container.ContainerID, expiration int64) {
-	fp.ready.PushBack(warmContainer{
+// putReadyContainer adds a container to the ready list.
+// Resources must be locked by the caller, to update the counters.
+func (fp *ContainerPool) putReadyContainer(contID container.ContainerID, memMB int64, now int64, expiration int64) {
+	warmed := warmContainer{
 		contID:     contID,
+		memMB:      memMB,
+		lastUsed:   now,
 		Expiration: expiration,
-	})
+	}
+	eviction.onReady(&warmed)
+	fp.ready.PushBack(warmed)
+	fp.warm++
 }
 
-func newFunctionPool(_ *function.Function) *ContainerPool {
+// removeReadyContainer removes a container from the ready list, releasing
+// its memory.
+// Resources must be locked by the caller, to update the counters.
+func (fp *ContainerPool) removeReadyContainer(elem *list.Element) warmContainer {
+	warmed := fp.ready.Remove(elem).(warmContainer)
+	fp.warm--
+	fp.containers--
+	releaseResources(0, warmed.memMB)
+	return warmed
+}
+
+func newFunctionPool(f *function.Function) *ContainerPool {
 	fp := &ContainerPool{}
 	fp.busy = list.New()
 	fp.ready = list.New()
+	fp.fun = f
 
 	return fp
 }
 
-// AcquireResources reserves the specified amount of cpu and memory if possible.
-func AcquireResources(cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) bool {
+// size returns the number of containers of the pool (busy, ready or being
+// created).
+// The function is NOT thread-safe.
+func (fp *ContainerPool) size() int {
+	return fp.containers + fp.starting
+}
+
+// unusedReservation returns the reserved CPUs and memory not currently used
+// by the function.
+// The function is NOT thread-safe.
+func (fp *ContainerPool) unusedReservation() (float64, int64) {
+	usedCPUs := float64(fp.inFlight+fp.starting) * fp.fun.CPUDemand
+	usedMemMB := int64(fp.size()) * fp.fun.MemoryMB
+	cpus := fp.fun.ReservedCPUs - usedCPUs
+	if cpus < 0.0 {
+		cpus = 0.0
+	}
+	return cpus, reservedShare(fp.fun, usedMemMB)
+}
+
+// reservedForOthers returns the CPUs and memory reserved to functions other
+// than f and not used by them.
+// The function is NOT thread-safe.
+func reservedForOthers(f *function.Function) (float64, int64) {
+	var cpus float64 = 0.0
+	var memMB int64 = 0
+	for name, fp := range Resources.ContainerPools {
+		if name == f.Name {
+			continue
+		}
+		c, m := fp.unusedReservation()
+		cpus += c
+		memMB += m
+	}
+	return cpus, memMB
+}
+
+// RegisterFunction makes the node aware of a function, so that its reserved
+// resources (if any) are not taken by other functions.
+func RegisterFunction(f *function.Function) {
 	Resources.Lock()
 	defer Resources.Unlock()
-	return acquireResources(cpuDemand, memDemand, destroyContainersIfNeeded)
+	getFunctionPool(f)
 }
 
-// acquireResources reserves the specified amount of cpu and memory if possible.
-// The function is NOT thread-safe.
-func acquireResources(cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) bool {
-	if Resources.AvailableCPUs < cpuDemand {
+// UnregisterFunction releases the resources reserved to a function (e.g., when
+// the function is deleted).
+func UnregisterFunction(name string) {
+	Resources.Lock()
+	defer Resources.Unlock()
+
+	fp, ok := Resources.ContainerPools[name]
+	if !ok {
+		return
+	}
+	fun := *fp.fun
+	fun.ReservedCPUs = 0.0
+	fun.ReservedMemMB = 0
+	fun.MinWarm = 0
+	fp.fun = &fun
+}
+
+// AcquireResources reserves the resources for a new container of the given
+// function, if possible.
+// The function fails if the function has reached its max concurrency.
+func AcquireResources(f *function.Function, destroyContainersIfNeeded bool) bool {
+	fp := lockFunctionPool(f)
+	defer fp.Unlock()
+	return acquireContainerResources(fp, f, destroyContainersIfNeeded) == nil
+}
+
+// acquireContainerResources reserves the resources for a new container of the
+// given function, if possible.
+// The pool of the function must be locked by the caller.
+func acquireContainerResources(fp *ContainerPool, f *function.Function, destroyContainersIfNeeded bool) error {
+	Resources.Lock()
+	defer Resources.Unlock()
+
+	if f.MaxConcurrency > 0 && int64(fp.size()) >= f.MaxConcurrency {
+		return ConcurrencyLimitErr
+	}
+	if !acquireResources(fp, f, f.CPUDemand, f.MemoryMB, destroyContainersIfNeeded) {
+		return OutOfResourcesErr
+	}
+	fp.starting++
+	return nil
+}
+
+// acquireResources reserves the specified amount of cpu and memory for a
+// function if possible. Resources reserved to other functions are not used.
+// The function is NOT thread-safe, and the pool of the function must be
+// locked by the caller.
+func acquireResources(fp *ContainerPool, f *function.Function, cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) bool {
+	reservedCPUs, reservedMemMB := reservedForOthers(f)
+	if Resources.AvailableCPUs-reservedCPUs < cpuDemand {
 		return false
 	}
-	if Resources.AvailableMemMB < memDemand {
+	if Resources.AvailableMemMB-reservedMemMB < memDemand {
 		if !destroyContainersIfNeeded {
 			return false
 		}
 
-		enoughMem, _ := dismissContainer(memDemand)
-		if !enoughMem {
+		// the memory reserved to other functions cannot be taken
+		requiredMemMB := memDemand
+		if reservedMemMB > Resources.AvailableMemMB {
+			requiredMemMB += reservedMemMB - Resources.AvailableMemMB
+		}
+		if !dismissContainer(fp, f, requiredMemMB) {
 			return false
 		}
 	}
@@ -112,66 +355,131 @@ func releaseResources(cpuDemand float64, memDemand int64) {
 // The function returns an error if either:
 // (i) the warm container does not exist
 // (ii) there are not enough resources to start the container
+// If enabled, the liveness of the container is checked first.
 func AcquireWarmContainer(f *function.Function) (container.ContainerID, error) {
-	Resources.Lock()
-	defer Resources.Unlock()
+	return acquireHealthyContainer(f)
+}
 
-	fp := getFunctionPool(f)
-	contID, found := fp.getWarmContainer()
-	if !found {
-		return "", NoWarmFoundErr
+// acquireWarmContainer acquires a warm container, returning when it became
+// ready as well.
+func acquireWarmContainer(f *function.Function) (container.ContainerID, int64, error) {
+	fp := lockFunctionPool(f)
+	defer fp.Unlock()
+
+	if !fp.hasWarmContainer(maxInFlight(f)) {
+		return "", 0, NoWarmFoundErr
 	}
 
-	if !acquireResources(f.CPUDemand, 0, false) {
+	Resources.Lock()
+	defer Resources.Unlock()
+
+	// resources are checked first, not to leave the container in the busy pool
+	if !acquireResources(fp, f, f.CPUDemand, 0, false) {
 		//log.Printf("Not enough CPU to start a warm container for %s", f)
-		return "", OutOfResourcesErr
+		return "", 0, OutOfResourcesErr
 	}
 
+	contID, readySince, _ := fp.getWarmContainer(maxInFlight(f))
+
 	//log.Printf("Acquired resources for warm container. Now: %v", Resources)
-	return contID, nil
+	return contID, readySince, nil
 }
 
-// ReleaseContainer puts a container in the ready pool for a function.
+// ReleaseContainer marks the end of an invocation served by a container. The
+// container goes back to the ready pool for the function as soon as no other
+// invocation is in flight.
 func ReleaseContainer(contID container.ContainerID, f *function.Function) {
-	// setup Expiration as time duration from now
-	d := time.Duration(config.GetInt(config.CONTAINER_EXPIRATION_TIME, 600)) * time.Second
-	expTime := time.Now().Add(d).UnixNano()
+	now := clock.Now()
 
+	fp := lockFunctionPool(f)
+	defer fp.Unlock()
 	Resources.Lock()
 	defer Resources.Unlock()
 
-	fp := getFunctionPool(f)
+	releaseResources(f.CPUDemand, 0)
 
-	// we must update the busy list by removing this element
-	elem := fp.busy.Front()
-	for ok := elem != nil; ok; ok = elem != nil {
-		if elem.Value.(container.ContainerID) == contID {
-			fp.busy.Remove(elem) // delete the element from the busy list
-			break
-		}
-		elem = elem.Next()
+	bc, idle := fp.releaseBusyContainer(contID)
+	if !idle {
+		return
+	}
+	if bc.discarded {
+		destroyDiscardedContainer(fp, bc)
+		return
+	}
+
+	keepAlive, prewarmAfter := fp.keepAlive(f)
+	if prewarmAfter > 0 && fp.containers == 1 && f.MinWarm == 0 {
+		// no invocation expected for a while: free the memory until then
+		destroyDiscardedContainer(fp, bc)
+		schedulePrewarm(f, prewarmAfter, keepAlive-prewarmAfter)
+		return
 	}
+	// setup Expiration as time duration from now
+	fp.putReadyContainer(contID, bc.memMB, now.UnixNano(), now.Add(keepAlive).UnixNano())
+
+	//log.Printf("Released resources. Now: %v", Resources)
+}
 
-	fp.putReadyContainer(contID, expTime)
+// DestroyContainer destroys a busy container that cannot be reused (e.g., the
+// function has timed out), releasing its resources. If other invocations are
+// in flight, the container is destroyed as soon as they complete, and it does
+// not serve further invocations.
+// Actual termination happens asynchronously.
+func DestroyContainer(contID container.ContainerID, f *function.Function) {
+	fp := lockFunctionPool(f)
+	defer fp.Unlock()
+	Resources.Lock()
+	defer Resources.Unlock()
 
 	releaseResources(f.CPUDemand, 0)
 
-	//log.Printf("Released resources. Now: %v", Resources)
+	bc, idle := fp.releaseBusyContainer(contID)
+	if bc == nil {
+		return
+	}
+	bc.discarded = true
+	if idle {
+		destroyDiscardedContainer(fp, bc)
+	}
+}
+
+// destroyDiscardedContainer releases the memory of a container removed from
+// the busy list, and destroys it.
+// The function is NOT thread-safe.
+func destroyDiscardedContainer(fp *ContainerPool, bc *busyContainer) {
+	fp.containers--
+	releaseResources(0, bc.memMB)
+	destroyContainers([]container.ContainerID{bc.contID})
+}
+
+// destroyContainers destroys containers in the background, as it may take a
+// while: their resources must have been released already.
+func destroyContainers(contIDs []container.ContainerID) {
+	if len(contIDs) == 0 {
+		return
+	}
+	go func() {
+		for _, contID := range contIDs {
+			if err := container.Destroy(contID); err != nil {
+				log.Printf("An error occurred while deleting %s: %v\n", contID, err)
+			}
+		}
+	}()
 }
 
 // NewContainer creates and starts a new container for the given function.
 // The container can be directly used to schedule a request, as it is already
 // in the busy pool.
 func NewContainer(fun *function.Function) (container.ContainerID, error) {
-	Resources.Lock()
-	if !acquireResources(fun.CPUDemand, fun.MemoryMB, true) {
+	fp := lockFunctionPool(fun)
+	if err := acquireContainerResources(fp, fun, true); err != nil {
 		//log.Printf("Not enough resources for the new container.")
-		Resources.Unlock()
-		return "", OutOfResourcesErr
+		fp.Unlock()
+		return "", err
 	}
 
 	//log.Printf("Acquired resources for new container. Now: %v", Resources)
-	Resources.Unlock()
+	fp.Unlock()
 
 	return NewContainerWithAcquiredResources(fun)
 }
@@ -193,134 +501,266 @@ func getImageForFunction(fun *function.Function) (string, error) {
 
 // NewContainerWithAcquiredResources spawns a new container for the given
 // function, assuming that the required CPU and memory resources have been
-// already been acquired.
+// already been acquired (through AcquireResources).
 func NewContainerWithAcquiredResources(fun *function.Function) (container.ContainerID, error) {
-	image, err := getImageForFunction(fun)
+	contID, err := createContainer(fun)
 	if err != nil {
 		return "", err
 	}
 
-	contID, err := container.NewContainer(image, fun.TarFunctionCode, &container.ContainerOptions{
-		MemoryMB: fun.MemoryMB,
-		CPUQuota: fun.CPUDemand,
-	})
+	fp := lockFunctionPool(fun)
+	defer fp.Unlock()
+	Resources.Lock()
+	defer Resources.Unlock()
+	fp.starting--
+	fp.containers++
+	fp.putBusyContainer(contID, fun.MemoryMB) // We immediately mark it as busy
+
+	return contID, nil
+}
 
+// newReadyContainer spawns a new container for the given function, which is
+// kept warm for keepAlive (0 for the default of the function) if not used.
+func newReadyContainer(fun *function.Function, keepAlive time.Duration, destroyContainersIfNeeded bool) error {
+	fp := lockFunctionPool(fun)
+	err := acquireContainerResources(fp, fun, destroyContainersIfNeeded)
+	fp.Unlock()
 	if err != nil {
-		log.Printf("Failed container creation: %v\n", err)
+		return err
 	}
 
+	contID, err := createContainer(fun)
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	fp = lockFunctionPool(fun)
+	defer fp.Unlock()
 	Resources.Lock()
 	defer Resources.Unlock()
+	if keepAlive <= 0 {
+		keepAlive, _ = fp.keepAlive(fun)
+	}
+	fp.starting--
+	fp.containers++
+	releaseResources(fun.CPUDemand, 0) // no invocation to serve yet
+	fp.putReadyContainer(contID, fun.MemoryMB, now.UnixNano(), now.Add(keepAlive).UnixNano())
+
+	return nil
+}
+
+// createContainer creates a container for the given function, whose
+// resources have been acquired already: they are released if creation fails.
+func createContainer(fun *function.Function) (container.ContainerID, error) {
+	image, err := getImageForFunction(fun)
+	var contID container.ContainerID
+	if err == nil {
+		contID, err = container.NewContainer(image, fun.TarFunctionCode, &container.ContainerOptions{
+			MemoryMB: fun.MemoryMB,
+			CPUQuota: fun.CPUDemand,
+			Labels: map[string]string{
+				container.NodeLabel:     NodeIdentifier,
+				container.AddressLabel:  NodeAddress,
+				container.FunctionLabel: fun.Name,
+				container.CodeHashLabel: fun.CodeHash(),
+			},
+		})
+		if err != nil {
+			log.Printf("Failed container creation: %v\n", err)
+		}
+	}
+
 	if err != nil {
+		Resources.Lock()
+		getFunctionPool(fun).starting--
 		releaseResources(fun.CPUDemand, fun.MemoryMB)
+		Resources.Unlock()
 		return "", err
 	}
-
-	fp := getFunctionPool(fun)
-	fp.putBusyContainer(contID) // We immediately mark it as busy
-
 	return contID, nil
 }
 
 type itemToDismiss struct {
-	contID container.ContainerID
-	pool   *ContainerPool
-	elem   *list.Element
-	memory int64
+	pool     *ContainerPool
+	elem     *list.Element
+	priority float64
 }
 
-// dismissContainer ... this function is used to get free memory used for a new container
-// 2-phases: first, we find ready container and collect them as a slice, second (cleanup phase) we delete the container only and only if
-// the sum of their memory is >= requiredMemoryMB is
-func dismissContainer(requiredMemoryMB int64) (bool, error) {
-	var cleanedMB int64 = 0
-	var containerToDismiss []itemToDismiss
-	res := false
+// dismissContainer frees memory for a new container of f, destroying ready
+// containers in the order chosen by the eviction policy. Containers are only
+// destroyed if enough memory (at least requiredMemoryMB) can be freed.
+// The memory freed by dismissing containers of other functions only counts as
+// far as it is not reserved to them.
+// The pools of other functions that are locked (i.e., in use) are skipped,
+// while the pool of f must be locked by the caller.
+// The function is NOT thread-safe.
+func dismissContainer(fp *ContainerPool, f *function.Function, requiredMemoryMB int64) bool {
+	var lockedPools []*ContainerPool
+	defer func() {
+		for _, pool := range lockedPools {
+			pool.Unlock()
+		}
+	}()
 
-	//first phase, research
+	// first phase, research
+	candidates := make([]itemToDismiss, 0)
 	for _, funPool := range Resources.ContainerPools {
-		if funPool.ready.Len() > 0 {
-			// every container into the funPool has the same memory (same function)
-			//so it is not important which one you destroy
-			elem := funPool.ready.Front()
-			contID := elem.Value.(warmContainer).contID
-			// container in the same pool need same memory
-			memory, _ := container.GetMemoryMB(contID)
-			for ok := true; ok; ok = elem != nil {
-				containerToDismiss = append(containerToDismiss,
-					itemToDismiss{contID: contID, pool: funPool, elem: elem, memory: memory})
-				cleanedMB += memory
-				if cleanedMB >= requiredMemoryMB {
-					goto cleanup
-				}
-				//go on to the next one
-				elem = elem.Next()
+		if funPool.warm == 0 {
+			continue
+		}
+		if funPool != fp {
+			if !funPool.TryLock() {
+				continue
 			}
+			lockedPools = append(lockedPools, funPool)
+		}
+		for elem := funPool.ready.Front(); elem != nil; elem = elem.Next() {
+			warmed := elem.Value.(warmContainer)
+			candidates = append(candidates, itemToDismiss{pool: funPool, elem: elem, priority: eviction.priority(funPool, &warmed)})
 		}
 	}
+	// ties are broken by function name, so that simulations are reproducible
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority < candidates[j].priority
+		}
+		return candidates[i].pool.fun.Name < candidates[j].pool.fun.Name
+	})
 
-cleanup: // second phase, cleanup
-	// memory check
-	if cleanedMB >= requiredMemoryMB {
-		for _, item := range containerToDismiss {
-			item.pool.ready.Remove(item.elem)     // remove the container from the funPool
-			err := container.Destroy(item.contID) // destroy the container
-			if err != nil {
-				res = false
-				return res, nil
-			}
-			Resources.AvailableMemMB += item.memory
+	var cleanedMB int64 = 0
+	var containerToDismiss []itemToDismiss
+	usedMemMB := make(map[*ContainerPool]int64)
+	dismissed := make(map[*ContainerPool]int)
+	for _, item := range candidates {
+		if cleanedMB >= requiredMemoryMB {
+			break
+		}
+		if item.pool.containers-dismissed[item.pool] <= int(item.pool.fun.MinWarm) {
+			continue // the containers kept at all times
+		}
+		used, ok := usedMemMB[item.pool]
+		if !ok {
+			used = int64(item.pool.size()) * item.pool.fun.MemoryMB
+		}
+		memory := item.elem.Value.(warmContainer).memMB
+		gain := memory
+		if item.pool != fp {
+			// memory going back to the reservation of the function
+			gain -= reservedShare(item.pool.fun, used-memory) - reservedShare(item.pool.fun, used)
 		}
+		usedMemMB[item.pool] = used - memory
+		if gain > 0 {
+			containerToDismiss = append(containerToDismiss, item)
+			dismissed[item.pool]++
+			cleanedMB += gain
+		}
+	}
 
-		res = true
+	// second phase, cleanup
+	if cleanedMB < requiredMemoryMB {
+		return false
 	}
-	return res, nil
+
+	contIDs := make([]container.ContainerID, 0, len(containerToDismiss))
+	for _, item := range containerToDismiss {
+		warmed := item.pool.removeReadyContainer(item.elem) // remove the container from the funPool
+		eviction.onEvicted(item.priority)
+		contIDs = append(contIDs, warmed.contID)
+	}
+	destroyContainers(contIDs)
+	return true
+}
+
+// reservedShare returns the memory reserved to a function and not used, given
+// the memory used by its containers.
+func reservedShare(f *function.Function, usedMemMB int64) int64 {
+	if f.ReservedMemMB > usedMemMB {
+		return f.ReservedMemMB - usedMemMB
+	}
+	return 0
 }
 
 // DeleteExpiredContainer is called by the container cleaner
-// Deletes expired warm container
+// Deletes expired warm container, and replaces those missing from the
+// containers kept at all times (MinWarm)
 func DeleteExpiredContainer() {
-	now := time.Now().UnixNano()
+	now := clock.Now().UnixNano()
 
-	Resources.Lock()
-	defer Resources.Unlock()
-
-	for _, pool := range Resources.ContainerPools {
+	for _, pool := range functionPools() {
+		pool.Lock()
+		Resources.Lock()
+		fun := pool.fun
+		expired := make([]container.ContainerID, 0)
 		elem := pool.ready.Front()
 		for ok := elem != nil; ok; ok = elem != nil {
 			warmed := elem.Value.(warmContainer)
-			if now > warmed.Expiration {
+			if now > warmed.Expiration && pool.containers > int(fun.MinWarm) {
 				temp := elem
 				elem = elem.Next()
 				log.Printf("cleaner: Removing container %s\n", warmed.contID)
-				pool.ready.Remove(temp) // remove the expired element
-
-				memory, _ := container.GetMemoryMB(warmed.contID)
-				releaseResources(0, memory)
-				err := container.Destroy(warmed.contID)
-				if err != nil {
-					log.Printf("Error while destroying container %s: %s\n", warmed.contID, err)
-				}
-				log.Printf("Released resources. Now: %v\n", &Resources)
+				pool.removeReadyContainer(temp) // remove the expired element
+				expired = append(expired, warmed.contID)
 			} else {
 				elem = elem.Next()
 			}
 		}
+		if len(expired) > 0 {
+			log.Printf("Released resources. Now: %v\n", &Resources)
+		}
+		Resources.Unlock()
+		pool.Unlock()
+
+		destroyContainers(expired)
+		pool.keepMinWarm()
+	}
+
+}
+
+// KeepMinWarm creates the containers missing from those of the function kept
+// at all times (MinWarm), e.g., when the function has just been created.
+func KeepMinWarm(f *function.Function) {
+	Resources.RLock()
+	fp, ok := Resources.ContainerPools[f.Name]
+	Resources.RUnlock()
+	if ok {
+		fp.keepMinWarm()
 	}
+}
 
+// keepMinWarm creates the containers missing from those kept at all times,
+// according to the latest definition of the function.
+func (fp *ContainerPool) keepMinWarm() {
+	fp.Lock()
+	Resources.RLock()
+	fun := fp.fun
+	missing := int(fun.MinWarm) - fp.size()
+	Resources.RUnlock()
+	fp.Unlock()
+
+	for ; missing > 0; missing-- {
+		if err := newReadyContainer(fun, 0, true); err != nil {
+			log.Printf("Could not create a warm container of %s: %v\n", fun, err)
+			break
+		}
+	}
 }
 
 // ShutdownWarmContainersFor destroys warm containers of a given function
 // Actual termination happens asynchronously.
 func ShutdownWarmContainersFor(f *function.Function) {
-	Resources.Lock()
-	defer Resources.Unlock()
-
+	Resources.RLock()
 	fp, ok := Resources.ContainerPools[f.Name]
+	Resources.RUnlock()
 	if !ok {
 		return
 	}
 
+	fp.Lock()
+	defer fp.Unlock()
+	Resources.Lock()
+	defer Resources.Unlock()
+
 	containersToDelete := make([]container.ContainerID, 0)
 
 	elem := fp.ready.Front()
@@ -329,75 +769,86 @@ func ShutdownWarmContainersFor(f *function.Function) {
 		temp := elem
 		elem = elem.Next()
 		log.Printf("Removing container with ID %s\n", warmed.contID)
-		fp.ready.Remove(temp)
-
-		memory, _ := container.GetMemoryMB(warmed.contID)
-		Resources.AvailableMemMB += memory
+		fp.removeReadyContainer(temp)
 		containersToDelete = append(containersToDelete, warmed.contID)
 	}
 
-	go func(contIDs []container.ContainerID) {
-		for _, contID := range contIDs {
-			// No need to update available resources here
-			if err := container.Destroy(contID); err != nil {
-				log.Printf("An error occurred while deleting %s: %v\n", contID, err)
-			} else {
-				log.Printf("Deleted %s\n", contID)
-			}
-		}
-	}(containersToDelete)
+	destroyContainers(containersToDelete)
 }
 
 // ShutdownAllContainers destroys all container (usually on termination)
 func ShutdownAllContainers() {
-	Resources.Lock()
-	defer Resources.Unlock()
+	containersToDelete := make([]container.ContainerID, 0)
+
+	for _, pool := range functionPools() {
+		pool.Lock()
+		Resources.Lock()
 
-	for fun, pool := range Resources.ContainerPools {
 		elem := pool.ready.Front()
 		for ok := elem != nil; ok; ok = elem != nil {
 			warmed := elem.Value.(warmContainer)
 			temp := elem
 			elem = elem.Next()
 			log.Printf("Removing container with ID %s\n", warmed.contID)
-			pool.ready.Remove(temp)
-
-			memory, _ := container.GetMemoryMB(warmed.contID)
-			err := container.Destroy(warmed.contID)
-			if err != nil {
-				log.Printf("Error while destroying container %s: %s", warmed.contID, err)
-			}
-			Resources.AvailableMemMB += memory
+			pool.removeReadyContainer(temp)
+			containersToDelete = append(containersToDelete, warmed.contID)
 		}
 
-		functionDescriptor, _ := function.GetFunction(fun)
-
 		elem = pool.busy.Front()
 		for ok := elem != nil; ok; ok = elem != nil {
-			contID := elem.Value.(container.ContainerID)
+			bc := elem.Value.(*busyContainer)
 			temp := elem
 			elem = elem.Next()
-			log.Printf("Removing container with ID %s\n", contID)
-			pool.ready.Remove(temp)
+			log.Printf("Removing container with ID %s\n", bc.contID)
+			pool.busy.Remove(temp)
+			pool.containers--
+			pool.inFlight -= bc.inFlight
+			releaseResources(float64(bc.inFlight)*pool.fun.CPUDemand, bc.memMB)
+			containersToDelete = append(containersToDelete, bc.contID)
+		}
 
-			memory, _ := container.GetMemoryMB(contID)
-			err := container.Destroy(contID)
-			if err != nil {
-				log.Printf("Error while destroying container %s: %s", contID, err)
-			}
-			Resources.AvailableMemMB += memory
-			Resources.AvailableCPUs += functionDescriptor.CPUDemand
+		Resources.Unlock()
+		pool.Unlock()
+	}
+
+	// the node is terminating: wait for the containers to be destroyed
+	for _, contID := range containersToDelete {
+		if err := container.Destroy(contID); err != nil {
+			log.Printf("Error while destroying container %s: %s\n", contID, err)
 		}
 	}
 }
 
+// ObserveExecution updates the estimated cold start time (used by the
+// eviction policy) and duration (used by the autoscaler) of a function with
+// a local execution.
+func ObserveExecution(f *function.Function, report *function.ExecutionReport) {
+	Resources.Lock()
+	defer Resources.Unlock()
+
+	fp := getFunctionPool(f)
+	if !report.IsWarmStart {
+		fp.coldStartTime = smooth(fp.coldStartTime, report.InitTime)
+	}
+	fp.duration = smooth(fp.duration, report.Duration)
+}
+
+// smooth returns the exponential moving average of the estimates with a new
+// sample (or the sample itself, if there are no estimates yet).
+func smooth(estimate float64, sample float64) float64 {
+	if estimate == 0.0 {
+		return sample
+	}
+	return estimateAlpha*sample + (1.0-estimateAlpha)*estimate
+}
+
 // WarmStatus foreach function returns the corresponding number of warm container available
 func WarmStatus() map[string]int {
 	Resources.RLock()
 	defer Resources.RUnlock()
 	warmPool := make(map[string]int)
 	for funcName, pool := range Resources.ContainerPools {
-		warmPool[funcName] = pool.ready.Len()
+		warmPool[funcName] = pool.warm
 	}
 
 	return warmPool
@@ -415,7 +866,7 @@ func PrewarmInstances(f *function.Function, count int64, forcePull bool) (int64,
 
 	var spawned int64 = 0
 	for spawned < count {
-		_, err = NewContainer(f)
+		err = newReadyContainer(f, 0, true)
 		if err != nil {
 			log.Printf("Prespawning failed: %v\n", err)
 			return spawned, err
@@ -0,0 +1,390 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// testClock is a clock moved forward by the tests, which records the
+// functions scheduled through AfterFunc (without calling them).
+type testClock struct {
+	sync.Mutex
+	now       time.Time
+	scheduled []time.Duration
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *testClock) AfterFunc(d time.Duration, f func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.scheduled = append(c.scheduled, d)
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// the factory is only set once, as containers may still be destroyed in the
+// background at the end of a test (or benchmark)
+var testFactory *container.SimulatedFactory
+var testFactoryOnce sync.Once
+
+func simulatedFactory() *container.SimulatedFactory {
+	testFactoryOnce.Do(func() {
+		testFactory = container.InitSimulatedContainerFactory()
+	})
+	return testFactory
+}
+
+// setupTest initializes the node with simulated containers and a test clock.
+func setupTest(t *testing.T, cpus float64, memMB int64) (*container.SimulatedFactory, *testClock) {
+	cf := simulatedFactory()
+	c := &testClock{now: time.Unix(1000, 0)}
+	clock.Set(c)
+	t.Cleanup(func() { clock.Set(clock.Real()) })
+	InitResources(cpus, memMB)
+	return cf, c
+}
+
+func newTestFunction(name string, memMB int64, cpuDemand float64) *function.Function {
+	return &function.Function{
+		Name:        name,
+		Runtime:     container.CUSTOM_RUNTIME,
+		CustomImage: "test",
+		MemoryMB:    memMB,
+		CPUDemand:   cpuDemand,
+	}
+}
+
+// readyContainers returns the ready containers of a function, from the
+// oldest.
+func readyContainers(f *function.Function) []container.ContainerID {
+	fp := lockFunctionPool(f)
+	defer fp.Unlock()
+	contIDs := make([]container.ContainerID, 0)
+	for elem := fp.ready.Front(); elem != nil; elem = elem.Next() {
+		contIDs = append(contIDs, elem.Value.(warmContainer).contID)
+	}
+	return contIDs
+}
+
+// checkCounters verifies the counters of the pools and the resources of the
+// node (with cpus and memMB in total) against the containers in the lists.
+// No container must be being created.
+func checkCounters(t *testing.T, cpus float64, memMB int64) {
+	t.Helper()
+	usedCPUs := 0.0
+	var usedMemMB int64 = 0
+	for _, fp := range functionPools() {
+		fp.Lock()
+		Resources.RLock()
+		inFlight := 0
+		for elem := fp.busy.Front(); elem != nil; elem = elem.Next() {
+			inFlight += elem.Value.(*busyContainer).inFlight
+		}
+		if fp.warm != fp.ready.Len() {
+			t.Errorf("%s: %d warm containers, %d ready", fp.fun, fp.warm, fp.ready.Len())
+		}
+		if fp.containers != fp.ready.Len()+fp.busy.Len() || fp.starting != 0 {
+			t.Errorf("%s: %d containers (%d starting), %d ready and %d busy", fp.fun, fp.containers, fp.starting, fp.ready.Len(), fp.busy.Len())
+		}
+		if fp.inFlight != inFlight {
+			t.Errorf("%s: %d invocations in flight, %d in the busy containers", fp.fun, fp.inFlight, inFlight)
+		}
+		usedCPUs += float64(fp.inFlight) * fp.fun.CPUDemand
+		usedMemMB += int64(fp.containers) * fp.fun.MemoryMB
+		Resources.RUnlock()
+		fp.Unlock()
+	}
+
+	Resources.RLock()
+	defer Resources.RUnlock()
+	if Resources.AvailableMemMB != memMB-usedMemMB {
+		t.Errorf("%d MB available, want %d", Resources.AvailableMemMB, memMB-usedMemMB)
+	}
+	if math.Abs(Resources.AvailableCPUs-(cpus-usedCPUs)) > 1e-9 {
+		t.Errorf("%v CPUs available, want %v", Resources.AvailableCPUs, cpus-usedCPUs)
+	}
+}
+
+func TestPoolCounters(t *testing.T) {
+	setupTest(t, 4, 1024)
+	f := newTestFunction("counters", 128, 1)
+	shared := newTestFunction("shared", 256, 0.5)
+	shared.MaxConcurrencyPerContainer = 2
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"cold start", func() error {
+			contID, err := NewContainer(f)
+			if err == nil {
+				ReleaseContainer(contID, f)
+			}
+			return err
+		}},
+		{"warm start", func() error {
+			contID, err := AcquireWarmContainer(f)
+			if err == nil {
+				ReleaseContainer(contID, f)
+			}
+			return err
+		}},
+		{"destroyed after use", func() error {
+			contID, err := AcquireWarmContainer(f)
+			if err == nil {
+				DestroyContainer(contID, f)
+			}
+			return err
+		}},
+		{"pre-warmed", func() error {
+			return newReadyContainer(f, 0, false)
+		}},
+		{"shared container", func() error {
+			first, err := NewContainer(shared)
+			if err != nil {
+				return err
+			}
+			second, err := AcquireWarmContainer(shared)
+			if err != nil {
+				return err
+			}
+			if second != first {
+				return fmt.Errorf("container not shared")
+			}
+			checkCounters(t, 4, 1024)
+			ReleaseContainer(first, shared)
+			ReleaseContainer(second, shared)
+			return nil
+		}},
+		{"shared container destroyed", func() error {
+			first, err := AcquireWarmContainer(shared)
+			if err != nil {
+				return err
+			}
+			second, err := AcquireWarmContainer(shared)
+			if err != nil {
+				return err
+			}
+			// destroyed once the other invocation completes
+			DestroyContainer(first, shared)
+			checkCounters(t, 4, 1024)
+			ReleaseContainer(second, shared)
+			return nil
+		}},
+		{"out of CPUs", func() error {
+			contIDs := make([]container.ContainerID, 0)
+			var err error
+			for err == nil {
+				var contID container.ContainerID
+				contID, err = NewContainer(f)
+				if err == nil {
+					contIDs = append(contIDs, contID)
+				}
+			}
+			checkCounters(t, 4, 1024)
+			for _, contID := range contIDs {
+				ReleaseContainer(contID, f)
+			}
+			if len(contIDs) != 4 || !errors.Is(err, OutOfResourcesErr) {
+				return fmt.Errorf("%d containers created: %v", len(contIDs), err)
+			}
+			return nil
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		checkCounters(t, 4, 1024)
+	}
+}
+
+func TestPoolCountersConcurrent(t *testing.T) {
+	setupTest(t, 8, 2048)
+	funcs := []*function.Function{
+		newTestFunction("a", 128, 1),
+		newTestFunction("b", 256, 0.5),
+		newTestFunction("c", 512, 2),
+	}
+	funcs[1].MaxConcurrencyPerContainer = 3
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 200; i++ {
+				f := funcs[rng.Intn(len(funcs))]
+				contID, err := AcquireWarmContainer(f)
+				if err != nil {
+					contID, err = NewContainer(f)
+				}
+				if err != nil {
+					continue // out of resources
+				}
+				if rng.Intn(10) == 0 {
+					DestroyContainer(contID, f)
+				} else {
+					ReleaseContainer(contID, f)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	checkCounters(t, 8, 2048)
+	Resources.RLock()
+	defer Resources.RUnlock()
+	if Resources.AvailableCPUs != 8 {
+		t.Errorf("%v CPUs available with no invocation in flight", Resources.AvailableCPUs)
+	}
+}
+
+func TestEvictionRespectsReservations(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved int64 // memory reserved to the function owning the warm containers
+		minWarm  int64
+		warm     int // containers of that function
+		created  int // containers of the other function that can be created
+		left     int // containers of the first function left
+	}{
+		{"no reservation", 0, 0, 3, 4, 0},
+		{"reserved containers kept", 256, 0, 2, 2, 2},
+		{"containers beyond the reservation evicted", 256, 0, 3, 2, 2},
+		{"MinWarm containers kept", 0, 1, 3, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, 16, 512)
+			owner := newTestFunction("owner", 128, 1)
+			owner.ReservedMemMB = tt.reserved
+			owner.MinWarm = tt.minWarm
+			other := newTestFunction("other", 128, 1)
+			RegisterFunction(owner)
+			for i := 0; i < tt.warm; i++ {
+				if err := newReadyContainer(owner, 0, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			created := 0
+			for {
+				if _, err := NewContainer(other); err != nil {
+					break
+				}
+				created++
+			}
+			if created != tt.created {
+				t.Errorf("%d containers created, want %d", created, tt.created)
+			}
+			if left := len(readyContainers(owner)); left != tt.left {
+				t.Errorf("%d warm containers left, want %d", left, tt.left)
+			}
+			checkCounters(t, 16, 512)
+		})
+	}
+}
+
+// benchLatency emulates the latency of the Docker API
+const benchLatency = 200 * time.Microsecond
+
+// setupBenchmark initializes the node with simulated containers, and returns
+// the given number of functions.
+func setupBenchmark(cpus float64, memMB int64, functions int) []*function.Function {
+	simulatedFactory().Latency = benchLatency
+	InitResources(cpus, memMB)
+
+	funcs := make([]*function.Function, functions)
+	for i := range funcs {
+		funcs[i] = &function.Function{
+			Name:        fmt.Sprintf("bench%d", i),
+			Runtime:     container.CUSTOM_RUNTIME,
+			CustomImage: "bench",
+			MemoryMB:    128,
+			CPUDemand:   0.1,
+		}
+	}
+	return funcs
+}
+
+// BenchmarkWarmStarts measures invocations of several functions served
+// concurrently by warm containers.
+func BenchmarkWarmStarts(b *testing.B) {
+	funcs := setupBenchmark(1000.0, 1000*128, 32)
+	for _, f := range funcs {
+		for i := 0; i < 8; i++ {
+			contID, err := NewContainer(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ReleaseContainer(contID, f)
+		}
+	}
+
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		f := funcs[int(next.Add(1))%len(funcs)]
+		for pb.Next() {
+			contID, err := AcquireWarmContainer(f)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			ReleaseContainer(contID, f)
+		}
+	})
+}
+
+// BenchmarkColdStartsWithEviction measures cold starts of several functions
+// served concurrently, each requiring the eviction of a warm container of
+// another function.
+func BenchmarkColdStartsWithEviction(b *testing.B) {
+	const functions = 32
+	funcs := setupBenchmark(1000.0, functions*128, functions)
+	for _, f := range funcs {
+		contID, err := NewContainer(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ReleaseContainer(contID, f)
+	}
+
+	var next atomic.Int64
+	var failed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f := funcs[int(next.Add(1))%len(funcs)]
+			contID, err := NewContainer(f)
+			if err != nil {
+				// all the warm containers are in use
+				failed.Add(1)
+				continue
+			}
+			ReleaseContainer(contID, f)
+		}
+	})
+	b.ReportMetric(float64(failed.Load())/float64(b.N), "failed/op")
+}
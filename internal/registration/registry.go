@@ -3,7 +3,9 @@ package registration
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/config"
@@ -165,3 +167,71 @@ func (r *Registry) Deregister() (e error) {
 	log.Println("Deregister : " + r.Key)
 	return nil
 }
+
+// peersTTL is how long the addresses of the registered nodes are cached
+const peersTTL = 5 * time.Second
+
+var peers = struct {
+	sync.Mutex
+	hosts      map[string]bool
+	updated    time.Time
+	refreshing chan struct{} // closed when the refresh in progress (if any) completes
+}{}
+
+// IsPeer checks whether an IP address is the one of a node registered in etcd
+// (in any Area). The addresses are cached for a few seconds, and so are the
+// failures to read them: etcd is only read by one caller at a time, while
+// the others use the addresses cached so far.
+func IsPeer(ip string) bool {
+	peers.Lock()
+	defer peers.Unlock()
+
+	if peers.refreshing == nil && time.Since(peers.updated) > peersTTL {
+		done := make(chan struct{})
+		peers.refreshing = done
+		peers.Unlock()
+		hosts, err := getRegisteredHosts()
+		peers.Lock()
+
+		if err != nil {
+			log.Printf("Could not read the registered nodes: %v\n", err)
+		} else {
+			peers.hosts = hosts
+		}
+		peers.updated = time.Now()
+		peers.refreshing = nil
+		close(done)
+	} else if peers.refreshing != nil && peers.hosts == nil {
+		// nothing cached yet: wait for the first refresh
+		done := peers.refreshing
+		peers.Unlock()
+		<-done
+		peers.Lock()
+	}
+	return peers.hosts[ip]
+}
+
+// getRegisteredHosts retrieves the hosts of all the registered nodes.
+func getRegisteredHosts() (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return nil, UnavailableClientErr
+	}
+
+	resp, err := etcdClient.Get(ctx, BASEDIR+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("Could not read from etcd: %v", err)
+	}
+
+	hosts := make(map[string]bool)
+	for _, s := range resp.Kvs {
+		u, err := url.Parse(string(s.Value))
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		hosts[u.Hostname()] = true
+	}
+	return hosts, nil
+}
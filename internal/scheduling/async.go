@@ -5,12 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/grussorusso/serverledge/internal/function"
 	"github.com/grussorusso/serverledge/utils"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// asyncResponseTimeout is how long the response of an async request offloaded
+// to another node is awaited
+const asyncResponseTimeout = 10 * time.Minute
+
 func publishAsyncResponse(reqId string, response function.Response) {
 	etcdClient, err := utils.GetEtcdClient()
 	if err != nil {
@@ -39,3 +44,39 @@ func publishAsyncResponse(reqId string, response function.Response) {
 		return
 	}
 }
+
+// awaitAsyncResponse waits for the response of an async request served by
+// another node, which publishes it to etcd. It fails if no response is
+// published within asyncResponseTimeout.
+func awaitAsyncResponse(reqId string) (function.Response, error) {
+	var response function.Response
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return response, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), asyncResponseTimeout)
+	defer cancel()
+
+	// watch before reading, not to miss a response published in between
+	key := fmt.Sprintf("async/%s", reqId)
+	watchChan := etcdClient.Watch(ctx, key)
+	resp, err := etcdClient.Get(ctx, key)
+	if err != nil {
+		return response, err
+	}
+	if len(resp.Kvs) > 0 {
+		err = json.Unmarshal(resp.Kvs[0].Value, &response)
+		return response, err
+	}
+
+	for watchResp := range watchChan {
+		for _, event := range watchResp.Events {
+			if event.Type == clientv3.EventTypePut {
+				err = json.Unmarshal(event.Kv.Value, &response)
+				return response, err
+			}
+		}
+	}
+	return response, fmt.Errorf("no response for %s: %v", reqId, ctx.Err())
+}
@@ -0,0 +1,303 @@
+package scheduling
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+// DecisionLogDisabledErr is returned when querying the decision log, if it
+// has not been enabled
+var DecisionLogDisabledErr = errors.New("the decision log is not enabled")
+
+// DecisionRecord describes a scheduling decision, as recorded in the decision
+// log.
+type DecisionRecord struct {
+	Time     time.Time
+	ReqId    string
+	Function string
+	Class    string
+	Policy   string
+	Action   string   // local, best-effort, offload, drop, drop-expired, drop-draining
+	Reasons  []string // why the policy did not (or could not) do otherwise
+	// local execution
+	Container string
+	WarmStart bool
+	// offloading
+	Target       string
+	TargetStatus *registration.StatusInformation // as last advertised by the target
+	// timings (s)
+	WaitTime float64 // from the arrival of the request to the decision
+	MaxRespT float64
+}
+
+var actionNames = map[action]string{
+	DROP:                  "drop",
+	EXEC_LOCAL:            "local",
+	EXEC_REMOTE:           "offload",
+	BEST_EFFORT_EXECUTION: "best-effort",
+	DROP_EXPIRED:          "drop-expired",
+	DROP_DRAINING:         "drop-draining",
+}
+
+// decisionLogBuffer is the number of records waiting to be written, beyond
+// which new records are dropped (rather than slowing down scheduling)
+const decisionLogBuffer = 1024
+
+// decisionLog writes the decision records to a JSONL file, which is rotated
+// when it exceeds maxBytes. The last backups files are kept (the most recent
+// one with suffix .1). Records are written by a dedicated goroutine.
+type decisionLog struct {
+	sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+	records  chan []byte
+	done     chan struct{}
+	dropped  atomic.Int64
+}
+
+// decisions is nil if the decision log is disabled
+var decisions *decisionLog
+
+// initDecisionLog opens the decision log, if enabled in the configuration.
+func initDecisionLog() {
+	if decisions != nil {
+		decisions.close()
+		decisions = nil
+	}
+
+	path := config.GetString(config.SCHEDULER_AUDIT_FILE, "")
+	if path == "" {
+		return
+	}
+	l := &decisionLog{
+		path:     path,
+		maxBytes: int64(config.GetInt(config.SCHEDULER_AUDIT_MAX_SIZE, 10)) * 1024 * 1024,
+		backups:  config.GetInt(config.SCHEDULER_AUDIT_BACKUPS, 3),
+		records:  make(chan []byte, decisionLogBuffer),
+		done:     make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		log.Printf("Could not open the decision log: %v\n", err)
+		return
+	}
+	go l.run()
+	decisions = l
+	log.Printf("Logging scheduling decisions to %s\n", path)
+}
+
+func (l *decisionLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// close writes the pending records and closes the file.
+func (l *decisionLog) close() {
+	close(l.records)
+	<-l.done
+
+	l.Lock()
+	defer l.Unlock()
+	if l.file != nil {
+		_ = l.file.Close()
+		l.file = nil
+	}
+}
+
+// backupPath returns the path of the i-th backup file (0 is the current
+// file).
+func (l *decisionLog) backupPath(i int) string {
+	if i == 0 {
+		return l.path
+	}
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// rotate moves the current file to the first backup, shifting the other
+// backups, and opens a new file.
+// The function is NOT thread-safe.
+func (l *decisionLog) rotate() error {
+	_ = l.file.Close()
+	l.file = nil
+	if l.backups < 1 {
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+		return l.open()
+	}
+	for i := l.backups; i > 0; i-- {
+		err := os.Rename(l.backupPath(i-1), l.backupPath(i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// enqueue passes a record to the writer goroutine, dropping it if too many
+// records are waiting.
+func (l *decisionLog) enqueue(record *DecisionRecord) {
+	// marshaled right away, as the request may change afterwards
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Could not marshal decision record: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	select {
+	case l.records <- line:
+	default:
+		if l.dropped.Add(1) == 1 {
+			log.Printf("Decision log full: dropping records\n")
+		}
+	}
+}
+
+// run writes the records enqueued, until the log is closed.
+func (l *decisionLog) run() {
+	defer close(l.done)
+	for line := range l.records {
+		l.write(line)
+		if dropped := l.dropped.Swap(0); dropped > 0 {
+			log.Printf("Decision log: %d records dropped\n", dropped)
+		}
+	}
+}
+
+func (l *decisionLog) write(line []byte) {
+	l.Lock()
+	defer l.Unlock()
+	if l.file != nil && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			log.Printf("Could not rotate the decision log: %v\n", err)
+		}
+	}
+	if l.file == nil {
+		return
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Could not write to the decision log: %v\n", err)
+	}
+}
+
+// logDecision records a decision about a request.
+func logDecision(r *scheduledRequest, d schedDecision) {
+	if decisions == nil {
+		return
+	}
+
+	now := clock.Now()
+	record := &DecisionRecord{
+		Time:      now,
+		ReqId:     r.ReqId,
+		Function:  r.Fun.Name,
+		Class:     r.Class.String(),
+		Policy:    currentPolicyName(),
+		Action:    actionNames[d.action],
+		Reasons:   r.reasons,
+		Container: d.contID,
+		WarmStart: r.ExecReport.IsWarmStart,
+		Target:    d.remoteHost,
+		WaitTime:  now.Sub(r.Arrival).Seconds(),
+		MaxRespT:  r.MaxRespT,
+	}
+	if d.action == EXEC_REMOTE && registration.Reg != nil && registration.Reg.NearbyServersMap != nil {
+		if status, ok := registration.Reg.NearbyServersMap[d.remoteHost]; ok && status != nil {
+			statusCopy := *status
+			record.TargetStatus = &statusCopy
+		}
+	}
+	decisions.enqueue(record)
+}
+
+// openFiles opens the backups and the current file, from the oldest to the
+// newest. Holding the lock, no rotation can happen in between, and the files
+// opened can be read even if rotated afterwards.
+func (l *decisionLog) openFiles() ([]*os.File, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	files := make([]*os.File, 0, l.backups+1)
+	for i := l.backups; i >= 0; i-- {
+		f, err := os.Open(l.backupPath(i))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			for _, opened := range files {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// QueryDecisions returns the most recent decisions recorded (at most limit,
+// from the oldest to the newest), possibly only those about a function or
+// a request (if not empty).
+func QueryDecisions(function string, reqId string, limit int) ([]DecisionRecord, error) {
+	l := decisions
+	if l == nil {
+		return nil, DecisionLogDisabledErr
+	}
+
+	files, err := l.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	records := make([]DecisionRecord, 0)
+	for _, f := range files {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record DecisionRecord
+			if json.Unmarshal(scanner.Bytes(), &record) != nil {
+				// e.g., a record being written
+				continue
+			}
+			if (function != "" && record.Function != function) || (reqId != "" && record.ReqId != reqId) {
+				continue
+			}
+			records = append(records, record)
+			if limit > 0 && len(records) > limit {
+				records = records[1:]
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
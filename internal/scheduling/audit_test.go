@@ -0,0 +1,109 @@
+package scheduling
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestDecisionLog enables a decision log in a temporary directory.
+func newTestDecisionLog(t *testing.T, maxBytes int64, backups int) *decisionLog {
+	l := &decisionLog{
+		path:     filepath.Join(t.TempDir(), "decisions.jsonl"),
+		maxBytes: maxBytes,
+		backups:  backups,
+		records:  make(chan []byte, decisionLogBuffer),
+		done:     make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		t.Fatal(err)
+	}
+	go l.run()
+
+	old := decisions
+	decisions = l
+	t.Cleanup(func() {
+		decisions = old
+	})
+	return l
+}
+
+func enqueueTestRecords(l *decisionLog, n int) {
+	for i := 0; i < n; i++ {
+		l.enqueue(&DecisionRecord{ReqId: fmt.Sprintf("req-%d", i), Function: fmt.Sprintf("f%d", i%2), Action: "local"})
+	}
+}
+
+func TestQueryDecisions(t *testing.T) {
+	l := newTestDecisionLog(t, 1024*1024, 3)
+	enqueueTestRecords(l, 10)
+	l.close()
+
+	tests := []struct {
+		name     string
+		function string
+		reqId    string
+		limit    int
+		want     []string
+	}{
+		{"all", "", "", 0, []string{"req-0", "req-1", "req-2", "req-3", "req-4", "req-5", "req-6", "req-7", "req-8", "req-9"}},
+		{"most recent", "", "", 3, []string{"req-7", "req-8", "req-9"}},
+		{"by function", "f1", "", 2, []string{"req-7", "req-9"}},
+		{"by request", "", "req-4", 0, []string{"req-4"}},
+		{"no match", "f2", "", 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := QueryDecisions(tt.function, tt.reqId, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(records))
+			for _, r := range records {
+				got = append(got, r.ReqId)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryDecisionsRotated(t *testing.T) {
+	// a few records per file: only the most recent ones are kept
+	l := newTestDecisionLog(t, 200, 2)
+	enqueueTestRecords(l, 50)
+	l.close()
+
+	records, err := QueryDecisions("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) >= 50 {
+		t.Fatalf("got %d records", len(records))
+	}
+	for i, r := range records {
+		if want := fmt.Sprintf("req-%d", 50-len(records)+i); r.ReqId != want {
+			t.Errorf("record %d: got %s, want %s", i, r.ReqId, want)
+		}
+	}
+}
+
+func TestQueryDecisionsWhileRotating(t *testing.T) {
+	l := newTestDecisionLog(t, 500, 2)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		enqueueTestRecords(l, 500)
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := QueryDecisions("", "", 0); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	l.close()
+}
@@ -13,6 +13,7 @@ func (p *CloudOnlyPolicy) OnArrival(r *scheduledRequest) {
 	if r.CanDoOffloading {
 		handleCloudOffload(r)
 	} else {
+		r.note("offloading not allowed")
 		dropRequest(r)
 	}
 }
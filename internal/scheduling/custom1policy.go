@@ -2,7 +2,6 @@ package scheduling
 
 import (
 	"github.com/grussorusso/serverledge/internal/function"
-	"github.com/grussorusso/serverledge/internal/node"
 )
 
 type Custom1Policy struct {
@@ -17,7 +16,7 @@ func (p *Custom1Policy) OnCompletion(_ *scheduledRequest) {
 
 func (p *Custom1Policy) OnArrival(r *scheduledRequest) {
 
-	containerID, err := node.AcquireWarmContainer(r.Fun)
+	containerID, err := acquireWarmContainer(r)
 	if err == nil {
 		execLocally(r, containerID, true)
 	} else if handleColdStart(r) {
@@ -0,0 +1,62 @@
+package scheduling
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// NodeDrainingErr is returned for requests refused because the node is
+// draining
+var NodeDrainingErr = errors.New("node is draining")
+
+// drainPollInterval is how often in-flight requests are checked while draining
+const drainPollInterval = 100 * time.Millisecond
+
+var draining atomic.Bool
+
+// inFlight counts the requests submitted and not completed yet (including
+// queued requests and async requests whose result has not been published)
+var inFlight atomic.Int64
+
+// IsDraining returns true if the node no longer accepts requests for local
+// execution.
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// Drain stops accepting requests for local execution, and waits for the
+// requests in flight to complete, until ctx is done. Requests arriving in the
+// meantime are offloaded (if allowed) or refused.
+// It returns false if some requests were still in flight when ctx was done.
+func Drain(ctx context.Context) bool {
+	draining.Store(true)
+	log.Printf("Draining: %d requests in flight\n", inFlight.Load())
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if inFlight.Load() == 0 {
+				return true
+			}
+		case <-ctx.Done():
+			log.Printf("Draining interrupted: %d requests in flight\n", inFlight.Load())
+			return false
+		}
+	}
+}
+
+// refuseWhileDraining handles a request arrived while draining, offloading it
+// if possible.
+func refuseWhileDraining(r *scheduledRequest) {
+	if r.CanDoOffloading && len(offloadingCandidates(r, "")) > 0 {
+		handleOffload(r, pickEdgeNodeForOffloading(r))
+	} else {
+		r.note("the node is draining")
+		sendDecision(r, schedDecision{action: DROP_DRAINING})
+	}
+}
@@ -1,9 +1,5 @@
 package scheduling
 
-import (
-	"github.com/grussorusso/serverledge/internal/node"
-)
-
 // CloudEdgePolicy supports only Edge-Cloud Offloading
 type CloudEdgePolicy struct{}
 
@@ -15,7 +11,7 @@ func (p *CloudEdgePolicy) OnCompletion(_ *scheduledRequest) {
 }
 
 func (p *CloudEdgePolicy) OnArrival(r *scheduledRequest) {
-	containerID, err := node.AcquireWarmContainer(r.Fun)
+	containerID, err := acquireWarmContainer(r)
 	if err == nil {
 		execLocally(r, containerID, true)
 	} else if handleColdStart(r) {
@@ -2,8 +2,6 @@ package scheduling
 
 import (
 	"log"
-
-	"github.com/grussorusso/serverledge/internal/node"
 )
 
 // EdgePolicy supports only Edge-Edge offloading
@@ -24,10 +22,11 @@ func (p *EdgePolicy) OnArrival(r *scheduledRequest) {
 			return
 		}
 	} else {
-		containerID, err := node.AcquireWarmContainer(r.Fun)
+		containerID, err := acquireWarmContainer(r)
 		if err == nil {
 			log.Printf("Using a warm container for: %v\n", r)
 			execLocally(r, containerID, true)
+			return
 		} else if handleColdStart(r) {
 			return
 		}
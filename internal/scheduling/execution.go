@@ -1,16 +1,18 @@
 package scheduling
 
 import (
+	"errors"
 	"fmt"
-	"time"
 
+	"github.com/grussorusso/serverledge/internal/clock"
 	"github.com/grussorusso/serverledge/internal/container"
 	"github.com/grussorusso/serverledge/internal/executor"
 )
 
 const HANDLER_DIR = "/app"
 
-// Execute serves a request on the specified container.
+// Execute serves a request on the specified container. The execution is
+// aborted if the request context is done in the meantime.
 func Execute(contID container.ContainerID, r *scheduledRequest) error {
 	//log.Printf("[%s] Executing on container: %v", r, contID)
 
@@ -19,6 +21,7 @@ func Execute(contID container.ContainerID, r *scheduledRequest) error {
 		req = executor.InvocationRequest{
 			Params:       r.Params,
 			ReturnOutput: r.ReturnOutput,
+			Timeout:      r.Fun.Timeout,
 		}
 	} else {
 		cmd := container.RuntimeToInfo[r.Fun.Runtime].InvocationCmd
@@ -28,35 +31,46 @@ func Execute(contID container.ContainerID, r *scheduledRequest) error {
 			Handler:      r.Fun.Handler,
 			HandlerDir:   HANDLER_DIR,
 			ReturnOutput: r.ReturnOutput,
+			Timeout:      r.Fun.Timeout,
 		}
 	}
 
-	t0 := time.Now()
+	t0 := clock.Now()
 
-	response, invocationWait, err := container.Execute(contID, &req)
+	response, invocationWait, err := container.Execute(r.ctx, contID, &req)
 	if err != nil {
-		// notify scheduler
-		completions <- &completion{scheduledRequest: r, contID: contID}
+		// notify scheduler (getting rid of the container if dead)
+		discard := errors.Is(err, container.ContainerNotRunningErr)
+		r.failed = r.ctx.Err() == nil
+		completions <- newCompletion(r, contID, discard)
 		return fmt.Errorf("[%s] Execution failed: %v", r, err)
 	}
 
+	if response.TimedOut {
+		// the container may be in a bad state: get rid of it
+		r.failed = true
+		completions <- newCompletion(r, contID, true)
+		return ExecutionTimeoutErr
+	}
+
 	if !response.Success {
 		// notify scheduler
-		completions <- &completion{scheduledRequest: r, contID: contID}
+		r.failed = true
+		completions <- newCompletion(r, contID, false)
 		return fmt.Errorf("Function execution failed")
 	}
 
 	r.ExecReport.Result = response.Result
 	r.ExecReport.Output = response.Output
-	r.ExecReport.Duration = time.Now().Sub(t0).Seconds() - invocationWait.Seconds()
-	r.ExecReport.ResponseTime = time.Now().Sub(r.Arrival).Seconds()
+	r.ExecReport.Duration = clock.Now().Sub(t0).Seconds() - invocationWait.Seconds()
+	r.ExecReport.ResponseTime = clock.Now().Sub(r.Arrival).Seconds()
 
 	// initializing containers may require invocation retries, adding
 	// latency
 	r.ExecReport.InitTime += invocationWait.Seconds()
 
 	// notify scheduler
-	completions <- &completion{scheduledRequest: r, contID: contID}
+	completions <- newCompletion(r, contID, false)
 
 	return nil
 }
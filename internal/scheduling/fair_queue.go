@@ -0,0 +1,157 @@
+package scheduling
+
+import (
+	"strings"
+	"sync"
+)
+
+// FairQueue keeps a separate queue for each function and serves them with
+// (weighted) Deficit Round Robin, so that a single function cannot monopolize
+// the node when requests pile up.
+type FairQueue struct {
+	sync.Mutex
+	flows    map[string]*fairFlow // flows with pending requests
+	active   []*fairFlow          // flows with pending requests, in round-robin order
+	current  int                  // index in active of the flow being served
+	size     int
+	capacity int // shared by all the functions
+	weights  map[string]float64
+	newQueue func(capacity int) queue
+}
+
+type fairFlow struct {
+	name    string
+	q       queue
+	weight  float64
+	deficit float64
+}
+
+// NewFairQueue creates a queue with the given capacity, shared by all the
+// functions. Each function with pending requests gets a queue, created
+// through newQueue. Weights are looked up by function name (lowercase), and
+// default to 1.
+func NewFairQueue(n int, weights map[string]float64, newQueue func(capacity int) queue) *FairQueue {
+	if n < 1 {
+		return nil
+	}
+	return &FairQueue{
+		flows:    make(map[string]*fairFlow),
+		active:   make([]*fairFlow, 0),
+		capacity: n,
+		weights:  weights,
+		newQueue: newQueue,
+	}
+}
+
+func (q *FairQueue) getFlow(name string) *fairFlow {
+	if flow, ok := q.flows[name]; ok {
+		return flow
+	}
+
+	weight, ok := q.weights[strings.ToLower(name)]
+	if !ok || weight <= 0.0 {
+		weight = 1.0
+	}
+	flow := &fairFlow{name: name, q: q.newQueue(q.capacity), weight: weight}
+	q.flows[name] = flow
+	return flow
+}
+
+// IsEmpty returns true if queue is empty
+func (q *FairQueue) IsEmpty() bool {
+	return q != nil && q.size == 0
+}
+
+// Enqueue pushes an element to the back of the queue of its function
+func (q *FairQueue) Enqueue(r *scheduledRequest) bool {
+	if q.size >= q.capacity {
+		return false
+	}
+	flow := q.getFlow(r.Fun.Name)
+	if !flow.q.Enqueue(r) {
+		return false
+	}
+	if flow.q.Len() == 1 {
+		flow.deficit = 0.0
+		q.active = append(q.active, flow)
+	}
+	q.size++
+	return true
+}
+
+// nextFlow returns the flow to be served next according to DRR, updating the
+// deficit counters as needed. Repeated calls return the same flow until an
+// element is dequeued.
+func (q *FairQueue) nextFlow() *fairFlow {
+	for {
+		flow := q.active[q.current]
+		if flow.deficit >= 1.0 {
+			return flow
+		}
+		// this flow spent its quantum: move on to the next one
+		flow.deficit += flow.weight
+		if flow.deficit < 1.0 {
+			q.current = (q.current + 1) % len(q.active)
+		}
+	}
+}
+
+// Dequeue fetches the next element according to DRR
+func (q *FairQueue) Dequeue() *scheduledRequest {
+	if q.IsEmpty() {
+		return nil
+	}
+
+	flow := q.nextFlow()
+	v := flow.q.Dequeue()
+	flow.deficit -= 1.0
+	q.size--
+
+	if flow.q.Len() == 0 {
+		q.deactivate(q.current)
+	} else if flow.deficit < 1.0 {
+		q.current = (q.current + 1) % len(q.active)
+	}
+	return v
+}
+
+// deactivate removes the i-th flow, which is empty, from the active list.
+// The flow is forgotten as well, until the function gets requests queued
+// again.
+func (q *FairQueue) deactivate(i int) {
+	delete(q.flows, q.active[i].name)
+	q.active = append(q.active[:i], q.active[i+1:]...)
+	if i < q.current {
+		q.current--
+	}
+	if q.current >= len(q.active) {
+		q.current = 0
+	}
+}
+
+// Front returns the next element according to DRR, without removing it
+func (q *FairQueue) Front() *scheduledRequest {
+	if q.IsEmpty() {
+		return nil
+	}
+	return q.nextFlow().q.Front()
+}
+
+// Len returns the current length of the queue
+func (q *FairQueue) Len() int {
+	return q.size
+}
+
+// RemoveIf removes the elements satisfying cond and returns them
+func (q *FairQueue) RemoveIf(cond func(r *scheduledRequest) bool) []*scheduledRequest {
+	removed := make([]*scheduledRequest, 0)
+	for i := len(q.active) - 1; i >= 0; i-- {
+		flow := q.active[i]
+		removed = append(removed, flow.q.RemoveIf(cond)...)
+		if flow.q.Len() == 0 {
+			q.deactivate(i)
+		}
+	}
+	q.size -= len(removed)
+	return removed
+}
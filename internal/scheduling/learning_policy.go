@@ -0,0 +1,255 @@
+package scheduling
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+)
+
+// learningMissPenalty is the extra cost of a request that missed its deadline
+const learningMissPenalty = 1.0
+
+// learningFailureCost is the cost of a request that could not be served (e.g.,
+// the site was unavailable or the execution failed), as if it had completed
+// at twice its max response time
+const learningFailureCost = 2.0 + learningMissPenalty
+
+// learningFailureRespT is the response time (s) charged to a request with no
+// max response time that could not be served
+const learningFailureRespT = 10.0
+
+// learningMaxUntriedTries is how many times an arm with no samples is tried
+// before the others (e.g., while the outcome of its requests is unknown)
+const learningMaxUntriedTries = 3
+
+// LearningPolicy learns where to serve the requests (locally, on a nearby
+// Edge node or in the Cloud) from the completed ones, as a contextual
+// multi-armed bandit. The context is given by the function, the service class
+// and the availability of a local warm container; each execution site is an
+// arm. The cost of an arm is the response time of the requests it served,
+// relative to their max response time (if any), with a penalty for deadline
+// misses; requests that cannot be served cost as much as missing the deadline
+// by its whole length. Arms are chosen in epsilon-greedy fashion: the cheapest
+// arm is chosen, except for a fraction epsilon of the requests, which explore
+// a random arm.
+type LearningPolicy struct {
+	sync.Mutex
+	alpha   float64
+	epsilon float64
+	rng     *rand.Rand
+	stats   map[learningContext]*[numLearningArms]ArmStats
+}
+
+type learningContext struct {
+	function string
+	class    function.ServiceClass
+}
+
+// learningArm is an execution site. Local execution is split in two arms,
+// depending on whether a warm container is available.
+type learningArm int
+
+const (
+	armLocalWarm learningArm = iota
+	armLocalCold
+	armEdge
+	armCloud
+	numLearningArms
+)
+
+var learningArmNames = [numLearningArms]string{"local-warm", "local-cold", "edge", "cloud"}
+
+// ArmStats keeps the exponentially weighted moving average of the cost of an
+// arm.
+type ArmStats struct {
+	Cost    float64
+	Samples int64
+	Tries   int64 // requests sent to the arm
+}
+
+type armOption struct {
+	arm learningArm
+	url string
+}
+
+func (p *LearningPolicy) Init() {
+	p.alpha = config.GetFloat(config.SCHEDULER_LEARNING_ALPHA, 0.2)
+	p.epsilon = config.GetFloat(config.SCHEDULER_LEARNING_EPSILON, 0.1)
+	p.rng = rand.New(rand.NewSource(clock.Now().UnixNano()))
+	p.stats = make(map[learningContext]*[numLearningArms]ArmStats)
+}
+
+// observesOffloads makes the policy learn from offloaded requests too.
+func (p *LearningPolicy) observesOffloads() {}
+
+func (p *LearningPolicy) OnCompletion(r *scheduledRequest) {
+	report := &r.ExecReport
+	if r.failed {
+		p.observe(r, servingArm(r), failureCost(r))
+		return
+	}
+	if report.ResponseTime <= 0.0 {
+		// the request was abandoned: nothing to learn
+		return
+	}
+
+	cost := report.ResponseTime
+	if r.MaxRespT > 0.0 {
+		cost = report.ResponseTime / r.MaxRespT
+		if report.ResponseTime > r.MaxRespT {
+			cost += learningMissPenalty
+		}
+	}
+	p.observe(r, servingArm(r), cost)
+}
+
+func (p *LearningPolicy) OnArrival(r *scheduledRequest) {
+	for _, opt := range p.rankArms(r) {
+		p.Lock()
+		p.getStats(r)[opt.arm].Tries++
+		p.Unlock()
+		if p.tryArm(r, opt) {
+			return
+		}
+		// the site is unavailable
+		p.observe(r, opt.arm, failureCost(r))
+	}
+	dropRequest(r)
+}
+
+// servingArm returns the arm that served (or failed to serve) a request.
+func servingArm(r *scheduledRequest) learningArm {
+	if r.remoteHost != "" && r.remoteHost == remoteServerUrl {
+		return armCloud
+	} else if r.remoteHost != "" {
+		return armEdge
+	} else if r.ExecReport.IsWarmStart {
+		return armLocalWarm
+	}
+	return armLocalCold
+}
+
+// failureCost returns the cost of a request that could not be served.
+func failureCost(r *scheduledRequest) float64 {
+	if r.MaxRespT > 0.0 {
+		return learningFailureCost
+	}
+	return learningFailureRespT
+}
+
+// observe updates the cost of an arm with a new sample.
+func (p *LearningPolicy) observe(r *scheduledRequest, arm learningArm, cost float64) {
+	p.Lock()
+	defer p.Unlock()
+	s := &p.getStats(r)[arm]
+	s.Cost = ewma(s.Cost, cost, p.alpha)
+	s.Samples++
+}
+
+// rankArms returns the arms available for a request, in the order they
+// should be tried. Arms with no samples come first (unless already tried
+// learningMaxUntriedTries times, in which case they come last), then the
+// cheapest ones. With probability epsilon, a random arm is moved to the
+// front.
+func (p *LearningPolicy) rankArms(r *scheduledRequest) []armOption {
+	options := make([]armOption, 0, 3)
+	if node.WarmStatus()[r.Fun.Name] > 0 {
+		options = append(options, armOption{arm: armLocalWarm})
+	} else {
+		options = append(options, armOption{arm: armLocalCold})
+	}
+	if r.CanDoOffloading {
+		if url := pickEdgeNodeForOffloading(r); url != "" {
+			options = append(options, armOption{arm: armEdge, url: url})
+		}
+		if remoteServerUrl != "" {
+			options = append(options, armOption{arm: armCloud, url: remoteServerUrl})
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	stats := p.getStats(r)
+	// arms to explore first, sampled arms, arms given up on
+	group := func(s ArmStats) int {
+		if s.Samples > 0 {
+			return 1
+		} else if s.Tries < learningMaxUntriedTries {
+			return 0
+		}
+		return 2
+	}
+	sort.SliceStable(options, func(i, j int) bool {
+		si, sj := stats[options[i].arm], stats[options[j].arm]
+		if group(si) != group(sj) {
+			return group(si) < group(sj)
+		}
+		return si.Cost < sj.Cost
+	})
+
+	if len(options) > 1 && p.rng.Float64() < p.epsilon {
+		i := p.rng.Intn(len(options))
+		explored := options[i]
+		copy(options[1:i+1], options[:i])
+		options[0] = explored
+	}
+
+	return options
+}
+
+// tryArm attempts to serve the request as the arm says, returning false if
+// the site turned out to be unavailable.
+func (p *LearningPolicy) tryArm(r *scheduledRequest, opt armOption) bool {
+	switch opt.arm {
+	case armLocalWarm, armLocalCold:
+		containerID, err := acquireWarmContainer(r)
+		if err == nil {
+			execLocally(r, containerID, true)
+			return true
+		}
+		return handleColdStart(r)
+	default:
+		handleOffload(r, opt.url)
+		return true
+	}
+}
+
+// getStats retrieves (or creates) the statistics of the arms for the context
+// of a request.
+// The function is NOT thread-safe.
+func (p *LearningPolicy) getStats(r *scheduledRequest) *[numLearningArms]ArmStats {
+	ctx := learningContext{function: r.Fun.Name, class: r.Class}
+	s, ok := p.stats[ctx]
+	if !ok {
+		s = &[numLearningArms]ArmStats{}
+		p.stats[ctx] = s
+	}
+	return s
+}
+
+// State returns the statistics of the arms, by function and service class.
+func (p *LearningPolicy) State() any {
+	p.Lock()
+	defer p.Unlock()
+
+	state := make(map[string]map[string]map[string]ArmStats)
+	for ctx, stats := range p.stats {
+		classes, ok := state[ctx.function]
+		if !ok {
+			classes = make(map[string]map[string]ArmStats)
+			state[ctx.function] = classes
+		}
+		arms := make(map[string]ArmStats)
+		for arm, s := range stats {
+			arms[learningArmNames[arm]] = s
+		}
+		classes[ctx.class.String()] = arms
+	}
+	return state
+}
@@ -0,0 +1,171 @@
+package scheduling
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+const testCloudUrl = "http://cloud"
+const testEdgeUrl = "http://edge1"
+
+// withOffloadingTargets makes a nearby Edge node and the Cloud available for
+// offloading.
+func withOffloadingTargets(t *testing.T) {
+	oldReg, oldRemote := registration.Reg, remoteServerUrl
+	t.Cleanup(func() {
+		registration.Reg, remoteServerUrl = oldReg, oldRemote
+	})
+	remoteServerUrl = testCloudUrl
+	registration.Reg = &registration.Registry{NearbyServersMap: map[string]*registration.StatusInformation{
+		testEdgeUrl: {Url: testEdgeUrl, AvailableWarmContainers: map[string]int{}, AvailableMemMB: 1024, AvailableCPUs: 4},
+	}}
+}
+
+func newTestLearningPolicy() *LearningPolicy {
+	return &LearningPolicy{
+		alpha:   0.5,
+		epsilon: 0.0,
+		rng:     rand.New(rand.NewSource(1)),
+		stats:   make(map[learningContext]*[numLearningArms]ArmStats),
+	}
+}
+
+func newTestLearningRequest(maxRespT float64) *scheduledRequest {
+	f := &function.Function{Name: "learningTest", MemoryMB: 128, CPUDemand: 1}
+	rq := &function.Request{Fun: f, CanDoOffloading: true}
+	rq.Class = function.LOW
+	rq.MaxRespT = maxRespT
+	return &scheduledRequest{Request: rq}
+}
+
+func TestLearningRankArms(t *testing.T) {
+	withOffloadingTargets(t)
+
+	tests := []struct {
+		name  string
+		stats map[learningArm]ArmStats
+		want  []learningArm
+	}{
+		{"nothing learned", nil,
+			[]learningArm{armLocalCold, armEdge, armCloud}},
+		{"cheapest first", map[learningArm]ArmStats{
+			armLocalCold: {Cost: 0.8, Samples: 10},
+			armEdge:      {Cost: 0.3, Samples: 10},
+			armCloud:     {Cost: 0.5, Samples: 10},
+		}, []learningArm{armEdge, armCloud, armLocalCold}},
+		{"untried first", map[learningArm]ArmStats{
+			armLocalCold: {Cost: 0.2, Samples: 10},
+			armEdge:      {Cost: 0.3, Samples: 10},
+			armCloud:     {Tries: learningMaxUntriedTries - 1},
+		}, []learningArm{armCloud, armLocalCold, armEdge}},
+		{"untried given up on", map[learningArm]ArmStats{
+			armLocalCold: {Cost: 0.9, Samples: 10},
+			armEdge:      {Tries: learningMaxUntriedTries},
+			armCloud:     {Cost: 0.5, Samples: 1},
+		}, []learningArm{armCloud, armLocalCold, armEdge}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestLearningPolicy()
+			r := newTestLearningRequest(1.0)
+			stats := p.getStats(r)
+			for arm, s := range tt.stats {
+				stats[arm] = s
+			}
+
+			options := p.rankArms(r)
+			if len(options) != len(tt.want) {
+				t.Fatalf("got %d arms, want %d", len(options), len(tt.want))
+			}
+			for i, opt := range options {
+				if opt.arm != tt.want[i] {
+					t.Errorf("arm %d: got %s, want %s", i, learningArmNames[opt.arm], learningArmNames[tt.want[i]])
+				}
+			}
+		})
+	}
+}
+
+func TestLearningRankArmsNoOffloading(t *testing.T) {
+	withOffloadingTargets(t)
+	p := newTestLearningPolicy()
+	r := newTestLearningRequest(1.0)
+	r.CanDoOffloading = false
+
+	options := p.rankArms(r)
+	if len(options) != 1 || options[0].arm != armLocalCold {
+		t.Errorf("got %v, want local execution only", options)
+	}
+}
+
+func TestLearningOnCompletion(t *testing.T) {
+	withOffloadingTargets(t)
+
+	tests := []struct {
+		name       string
+		maxRespT   float64
+		report     function.ExecutionReport
+		remoteHost string
+		failed     bool
+		arm        learningArm
+		cost       float64 // NaN if nothing is learned
+	}{
+		{"warm start", 1.0, function.ExecutionReport{ResponseTime: 0.5, IsWarmStart: true}, "", false,
+			armLocalWarm, 0.5},
+		{"cold start missing the deadline", 1.0, function.ExecutionReport{ResponseTime: 2.0}, "", false,
+			armLocalCold, 2.0 + learningMissPenalty},
+		{"no deadline", 0.0, function.ExecutionReport{ResponseTime: 0.3, SchedAction: SCHED_ACTION_OFFLOAD}, testCloudUrl, false,
+			armCloud, 0.3},
+		{"offloaded to the Edge", 2.0, function.ExecutionReport{ResponseTime: 0.5, SchedAction: SCHED_ACTION_OFFLOAD}, testEdgeUrl, false,
+			armEdge, 0.25},
+		{"offloading failed", 1.0, function.ExecutionReport{}, testEdgeUrl, true,
+			armEdge, learningFailureCost},
+		{"execution failed, no deadline", 0.0, function.ExecutionReport{IsWarmStart: true}, "", true,
+			armLocalWarm, learningFailureRespT},
+		{"abandoned", 1.0, function.ExecutionReport{}, "", false,
+			armLocalCold, math.NaN()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestLearningPolicy()
+			r := newTestLearningRequest(tt.maxRespT)
+			r.ExecReport = tt.report
+			r.remoteHost = tt.remoteHost
+			r.failed = tt.failed
+
+			p.OnCompletion(r)
+
+			for arm, s := range p.getStats(r) {
+				if learningArm(arm) != tt.arm || math.IsNaN(tt.cost) {
+					if s.Samples != 0 {
+						t.Errorf("%s: unexpected sample", learningArmNames[arm])
+					}
+					continue
+				}
+				if s.Samples != 1 || math.Abs(s.Cost-tt.cost) > 1e-9 {
+					t.Errorf("%s: got cost %v (%d samples), want %v", learningArmNames[arm], s.Cost, s.Samples, tt.cost)
+				}
+			}
+		})
+	}
+}
+
+func TestLearningCostAverage(t *testing.T) {
+	p := newTestLearningPolicy()
+	r := newTestLearningRequest(0.0)
+	r.ExecReport = function.ExecutionReport{ResponseTime: 1.0, IsWarmStart: true}
+	p.OnCompletion(r)
+	r.ExecReport.ResponseTime = 3.0
+	p.OnCompletion(r)
+
+	s := p.getStats(r)[armLocalWarm]
+	if s.Samples != 2 || s.Cost != 2.0 {
+		t.Errorf("got cost %v (%d samples), want 2 (2 samples)", s.Cost, s.Samples)
+	}
+}
@@ -2,14 +2,19 @@ package scheduling
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
-	"time"
+	"net/url"
+	"sort"
 
 	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/clock"
 	"github.com/grussorusso/serverledge/internal/function"
 	"github.com/grussorusso/serverledge/internal/node"
 	"github.com/grussorusso/serverledge/internal/registration"
@@ -17,65 +22,246 @@ import (
 
 const SCHED_ACTION_OFFLOAD = "O"
 
+// maxOffloadAttempts is the max number of nodes tried to offload a request
+var maxOffloadAttempts int
+
 func pickEdgeNodeForOffloading(r *scheduledRequest) (url string) {
+	candidates := edgeNodesForOffloading(r)
+	if len(candidates) < 1 {
+		r.note("no Edge node available for offloading")
+		return ""
+	}
+	return candidates[0]
+}
+
+// edgeNodesForOffloading returns the URLs of the nearby nodes that can
+// (likely) serve a request: nodes with a warm container come first, followed
+// by nodes with enough available memory for a cold start. Nodes the request
+// has already gone through are excluded. Nodes are considered by URL, so that
+// simulations are reproducible.
+func edgeNodesForOffloading(r *scheduledRequest) []string {
+	urls := make([]string, 0)
+	if registration.Reg == nil {
+		// Edge monitoring is not active (e.g., Cloud nodes)
+		return urls
+	}
 	nearbyServersMap := registration.Reg.NearbyServersMap
 	if nearbyServersMap == nil {
-		return ""
+		return urls
 	}
-	//first, search for warm container
+	nearbyServers := make([]*registration.StatusInformation, 0, len(nearbyServersMap))
 	for _, v := range nearbyServersMap {
+		nearbyServers = append(nearbyServers, v)
+	}
+	sort.Slice(nearbyServers, func(i, j int) bool { return nearbyServers[i].Url < nearbyServers[j].Url })
+
+	//first, search for warm container
+	for _, v := range nearbyServers {
+		if r.Offloading.HasVisited(v.Url) {
+			continue
+		}
 		if v.AvailableWarmContainers[r.Fun.Name] != 0 && v.AvailableCPUs >= r.Request.Fun.CPUDemand {
-			return v.Url
+			urls = append(urls, v.Url)
 		}
 	}
 	//second, (nobody has warm container) search for available memory
-	for _, v := range nearbyServersMap {
-		if v.AvailableMemMB >= r.Request.Fun.MemoryMB && v.AvailableCPUs >= r.Request.Fun.CPUDemand {
-			return v.Url
+	for _, v := range nearbyServers {
+		if r.Offloading.HasVisited(v.Url) {
+			continue
+		}
+		if v.AvailableWarmContainers[r.Fun.Name] == 0 && v.AvailableMemMB >= r.Request.Fun.MemoryMB && v.AvailableCPUs >= r.Request.Fun.CPUDemand {
+			urls = append(urls, v.Url)
+		}
+	}
+	return urls
+}
+
+// offloadingCandidates returns the ordered list of nodes to try for
+// offloading: the selected node first, then the other nearby Edge nodes and
+// finally the Cloud. The current node and the nodes the request has already
+// gone through are excluded, to avoid loops.
+func offloadingCandidates(r *scheduledRequest, selected string) []string {
+	candidates := make([]string, 0)
+	seen := map[string]bool{"": true, selfUrl: true}
+	for _, v := range r.Offloading.Visited {
+		seen[v] = true
+	}
+
+	others := append([]string{selected}, edgeNodesForOffloading(r)...)
+	others = append(others, remoteServerUrl)
+	for _, candidate := range others {
+		if !seen[candidate] {
+			candidates = append(candidates, candidate)
+			seen[candidate] = true
 		}
 	}
-	return ""
+	return candidates
+}
+
+// isRetriableOffloadError checks whether offloading can be attempted on another
+// node after a failure, i.e., the node refused the request or could not be
+// reached. Errors that may come from the function execution itself are not
+// retried.
+func isRetriableOffloadError(err error) bool {
+	var remoteErr *remoteStatusErr
+	var urlErr *url.Error
+	if errors.Is(err, node.OutOfResourcesErr) {
+		return true
+	} else if errors.As(err, &remoteErr) {
+		return remoteErr.statusCode == http.StatusServiceUnavailable
+	} else {
+		return errors.As(err, &urlErr)
+	}
+}
+
+// offloadWithFallback offloads a request to the selected node, walking the
+// list of alternative candidates if the node refuses or cannot be reached.
+// At most maxOffloadAttempts nodes are tried, and only as long as the
+// request deadline (if any) has not expired and the request has not been
+// abandoned. Every attempt is recorded in the execution report.
+func offloadWithFallback(r *scheduledRequest, selected string, offload func(context.Context, *function.Request, string) error) error {
+	attempts := make([]function.OffloadAttempt, 0)
+	// fails if there is no node to try
+	var err error = node.OutOfResourcesErr
+
+	for _, target := range offloadingCandidates(r, selected) {
+		if len(attempts) >= maxOffloadAttempts {
+			break
+		}
+		if r.MaxRespT > 0.0 && remainingTime(r.Request) <= 0.0 {
+			err = DeadlineExceededErr
+			break
+		}
+
+		t0 := clock.Now()
+		err = offload(r.ctx, r.Request, target)
+		attempt := function.OffloadAttempt{Url: target, Success: err == nil, Elapsed: clock.Now().Sub(t0).Seconds()}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		attempts = append(attempts, attempt)
+
+		if err == nil {
+			r.remoteHost = target
+			break
+		} else if r.abandoned() || !isRetriableOffloadError(err) {
+			break
+		}
+		log.Printf("[%s] Offloading to %s failed (%v): trying another node\n", r, target, err)
+	}
+
+	r.ExecReport.OffloadAttempts = attempts
+	return err
+}
+
+// remainingTime returns the time left (in seconds) before the request deadline.
+func remainingTime(r *function.Request) float64 {
+	return r.MaxRespT - clock.Now().Sub(r.Arrival).Seconds()
+}
+
+// remoteStatusErr is returned when the remote node replies with an
+// unexpected status code
+type remoteStatusErr struct {
+	statusCode int
+}
+
+func (e *remoteStatusErr) Error() string {
+	return fmt.Sprintf("Remote returned: %v", e.statusCode)
+}
+
+// OffloadErr is returned when a request could not be offloaded to any of the
+// nodes tried
+type OffloadErr struct {
+	Attempts []function.OffloadAttempt
+	Err      error // the reason of the failure
+}
+
+func (e *OffloadErr) Error() string {
+	return fmt.Sprintf("offloading failed after %d attempts: %v", len(e.Attempts), e.Err)
+}
+
+func (e *OffloadErr) Unwrap() error {
+	return e.Err
+}
+
+// newOffloadingRequest prepares the invocation request to send to another
+// node, updating the offloading metadata.
+func newOffloadingRequest(r *function.Request, async bool) client.InvocationRequest {
+	hops := r.Offloading.Hops + 1
+	visited := make([]string, 0, len(r.Offloading.Visited)+1)
+	visited = append(visited, r.Offloading.Visited...)
+	visited = append(visited, selfUrl)
+
+	return client.InvocationRequest{
+		Params:          r.Params,
+		QoSClass:        int64(r.Class),
+		QoSMaxRespT:     r.MaxRespT,
+		CanDoOffloading: r.CanDoOffloading && hops < r.Offloading.MaxHops,
+		Async:           async,
+		ReturnOutput:    r.ReturnOutput,
+		Envelope: &client.OffloadEnvelope{
+			ReqId:          r.ReqId,
+			Hops:           hops,
+			MaxHops:        r.Offloading.MaxHops,
+			Visited:        visited,
+			RemainingRespT: remoteMaxRespT(r),
+		},
+	}
+}
+
+// postInvocation sends an invocation request to another node. The request is
+// cancelled as soon as ctx is done.
+func postInvocation(ctx context.Context, serverUrl string, r *function.Request, invocationBody []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverUrl+"/invoke/"+r.Fun.Name,
+		bytes.NewReader(invocationBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return offloadingClient.Do(req)
 }
 
-func Offload(r *function.Request, serverUrl string) error {
+func Offload(ctx context.Context, r *function.Request, serverUrl string) error {
 	// Prepare request
-	request := client.InvocationRequest{Params: r.Params, QoSClass: int64(r.Class), QoSMaxRespT: r.MaxRespT}
+	request := newOffloadingRequest(r, false)
 	invocationBody, err := json.Marshal(request)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	sendingTime := time.Now() // used to compute latency later on
-	resp, err := offloadingClient.Post(serverUrl+"/invoke/"+r.Fun.Name, "application/json",
-		bytes.NewBuffer(invocationBody))
+	sendingTime := clock.Now() // used to compute latency later on
+	resp, err := postInvocation(ctx, serverUrl, r, invocationBody)
 
 	if err != nil {
 		log.Print(err)
 		return err
 	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("Error while closing offload response body: %s\n", err)
+		}
+	}(resp.Body)
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusTooManyRequests {
 			return node.OutOfResourcesErr
+		} else if resp.StatusCode == http.StatusGatewayTimeout {
+			return DeadlineExceededErr
 		}
-		return fmt.Errorf("Remote returned: %v", resp.StatusCode)
+		return &remoteStatusErr{resp.StatusCode}
 	}
 
 	var response function.Response
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Printf("Error while closing offload response body: %s\n", err)
-		}
-	}(resp.Body)
 	body, _ := io.ReadAll(resp.Body)
 	if err = json.Unmarshal(body, &response); err != nil {
 		return err
 	}
+	attempts := r.ExecReport.OffloadAttempts
 	r.ExecReport = response.ExecutionReport
-	now := time.Now()
-	response.ExecutionReport.ResponseTime = now.Sub(r.Arrival).Seconds()
+	r.ExecReport.OffloadAttempts = attempts
+	now := clock.Now()
+	r.ExecReport.ResponseTime = now.Sub(r.Arrival).Seconds()
 
-	// TODO: check how this is used in the QoSAware policy
 	// It was originially computed as "report.Arrival - sendingTime"
 	r.ExecReport.OffloadLatency = now.Sub(sendingTime).Seconds() - r.ExecReport.Duration - r.ExecReport.InitTime
 	r.ExecReport.SchedAction = SCHED_ACTION_OFFLOAD
@@ -83,28 +269,59 @@ func Offload(r *function.Request, serverUrl string) error {
 	return nil
 }
 
-func OffloadAsync(r *function.Request, serverUrl string) error {
+func OffloadAsync(ctx context.Context, r *function.Request, serverUrl string) error {
 	// Prepare request
-	request := client.InvocationRequest{Params: r.Params,
-		QoSClass:    int64(r.Class),
-		QoSMaxRespT: r.MaxRespT,
-		Async:       true}
+	request := newOffloadingRequest(r, true)
 	invocationBody, err := json.Marshal(request)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	resp, err := offloadingClient.Post(serverUrl+"/invoke/"+r.Fun.Name, "application/json",
-		bytes.NewBuffer(invocationBody))
+	resp, err := postInvocation(ctx, serverUrl, r, invocationBody)
 
 	if err != nil {
 		log.Print(err)
 		return err
 	}
+	_ = resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Remote returned: %v", resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return node.OutOfResourcesErr
+		}
+		return &remoteStatusErr{resp.StatusCode}
 	}
 
 	// there is nothing to wait for
 	return nil
 }
+
+// completeAsyncOffload waits for the response of an async request offloaded to
+// another node, and notifies the scheduler of its completion.
+func completeAsyncOffload(r *scheduledRequest) {
+	response, err := awaitAsyncResponse(r.ReqId)
+	if err != nil || !response.Success {
+		if err != nil {
+			log.Printf("[%s] No response from %s: %v\n", r, r.remoteHost, err)
+		}
+		r.failed = true
+	} else {
+		attempts := r.ExecReport.OffloadAttempts
+		r.ExecReport = response.ExecutionReport
+		r.ExecReport.OffloadAttempts = attempts
+		r.ExecReport.ResponseTime = clock.Now().Sub(r.Arrival).Seconds()
+		// including the time spent waiting on the remote node
+		r.ExecReport.OffloadLatency = r.ExecReport.ResponseTime - r.ExecReport.Duration - r.ExecReport.InitTime
+		r.ExecReport.SchedAction = SCHED_ACTION_OFFLOAD
+	}
+	completions <- newCompletion(r, "", false)
+}
+
+// remoteMaxRespT returns the max response time left for a remote node, i.e.,
+// what is left of the original one.
+func remoteMaxRespT(r *function.Request) float64 {
+	if r.MaxRespT <= 0.0 {
+		return r.MaxRespT
+	}
+	// a non-positive value would mean "no deadline"
+	return math.Max(remainingTime(r), 0.001)
+}
@@ -0,0 +1,209 @@
+package scheduling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+)
+
+func TestOffloadingCandidates(t *testing.T) {
+	neighbours := []string{"http://edge3", "http://edge1", "http://self", "http://edge2"}
+	tests := []struct {
+		name     string
+		cloud    string
+		selected string
+		visited  []string
+		want     []string
+	}{
+		{"selected first", "http://cloud", "http://edge2", nil, []string{"http://edge2", "http://edge1", "http://edge3", "http://cloud"}},
+		{"none selected", "http://cloud", "", nil, []string{"http://edge1", "http://edge2", "http://edge3", "http://cloud"}},
+		{"Cloud selected", "http://cloud", "http://cloud", nil, []string{"http://cloud", "http://edge1", "http://edge2", "http://edge3"}},
+		{"no Cloud", "", "", nil, []string{"http://edge1", "http://edge2", "http://edge3"}},
+		{"visited excluded", "http://cloud", "http://edge2", []string{"http://edge2", "http://cloud", "http://edge3"}, []string{"http://edge1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOffloadingTest(t, tt.cloud, neighbours...)
+			r := newTestRequest(true, time.Now())
+			r.Offloading.Visited = tt.visited
+
+			if got := offloadingCandidates(r, tt.selected); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetriableOffloadError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no resources", node.OutOfResourcesErr, true},
+		{"unreachable", &url.Error{Op: "Post", URL: "http://edge1", Err: errors.New("connection refused")}, true},
+		{"draining", &remoteStatusErr{http.StatusServiceUnavailable}, true},
+		{"bad request", &remoteStatusErr{http.StatusBadRequest}, false},
+		{"forbidden", &remoteStatusErr{http.StatusForbidden}, false},
+		{"failed", &remoteStatusErr{http.StatusInternalServerError}, false},
+		{"deadline exceeded", DeadlineExceededErr, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetriableOffloadError(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffloadWithFallback(t *testing.T) {
+	refused := node.OutOfResourcesErr
+	unreachable := &url.Error{Op: "Post", URL: "http://edge1", Err: errors.New("connection refused")}
+	tests := []struct {
+		name     string
+		attempts int
+		maxRespT float64
+		errs     map[string]error // returned by each node (nil: served)
+		elapsed  time.Duration    // by each attempt
+		tried    []string
+		err      error
+	}{
+		{"served by the selected node", 3, 0, nil, 0,
+			[]string{"http://edge2"}, nil},
+		{"refused", 3, 0, map[string]error{"http://edge2": refused}, 0,
+			[]string{"http://edge2", "http://edge1"}, nil},
+		{"unreachable and draining", 3, 0, map[string]error{"http://edge2": unreachable, "http://edge1": &remoteStatusErr{http.StatusServiceUnavailable}}, 0,
+			[]string{"http://edge2", "http://edge1", "http://cloud"}, nil},
+		{"client error", 3, 0, map[string]error{"http://edge2": &remoteStatusErr{http.StatusBadRequest}}, 0,
+			[]string{"http://edge2"}, &remoteStatusErr{http.StatusBadRequest}},
+		{"max attempts", 2, 0, map[string]error{"http://edge2": refused, "http://edge1": refused}, 0,
+			[]string{"http://edge2", "http://edge1"}, refused},
+		{"all refused", 5, 0, map[string]error{"http://edge2": refused, "http://edge1": refused, "http://cloud": refused}, 0,
+			[]string{"http://edge2", "http://edge1", "http://cloud"}, refused},
+		{"deadline expired meanwhile", 3, 1.5, map[string]error{"http://edge2": refused, "http://edge1": refused}, time.Second,
+			[]string{"http://edge2", "http://edge1"}, DeadlineExceededErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOffloadingTest(t, "http://cloud", "http://edge1", "http://edge2")
+			oldAttempts := maxOffloadAttempts
+			maxOffloadAttempts = tt.attempts
+			c := &simClock{now: time.Unix(1000, 0)}
+			clock.Set(c)
+			t.Cleanup(func() {
+				maxOffloadAttempts = oldAttempts
+				clock.Set(clock.Real())
+			})
+
+			r := newTestRequest(true, c.Now())
+			r.MaxRespT = tt.maxRespT
+			tried := make([]string, 0)
+			err := offloadWithFallback(r, "http://edge2", func(_ context.Context, _ *function.Request, serverUrl string) error {
+				tried = append(tried, serverUrl)
+				c.Lock()
+				c.now = c.now.Add(tt.elapsed)
+				c.Unlock()
+				return tt.errs[serverUrl]
+			})
+
+			if fmt.Sprint(tried) != fmt.Sprint(tt.tried) {
+				t.Errorf("tried %v, want %v", tried, tt.tried)
+			}
+			if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			attempts := r.ExecReport.OffloadAttempts
+			if len(attempts) != len(tried) {
+				t.Fatalf("%d attempts recorded, %d tried", len(attempts), len(tried))
+			}
+			for i, a := range attempts {
+				if a.Url != tried[i] || a.Success != (tt.errs[a.Url] == nil) || a.Elapsed != tt.elapsed.Seconds() {
+					t.Errorf("attempt %d: got %+v", i, a)
+				}
+			}
+			if tt.err == nil && r.remoteHost != tried[len(tried)-1] {
+				t.Errorf("got remote host %s, want %s", r.remoteHost, tried[len(tried)-1])
+			}
+		})
+	}
+}
+
+func TestOffloadWithFallbackNoCandidates(t *testing.T) {
+	setupOffloadingTest(t, "")
+	r := newTestRequest(true, time.Now())
+	err := offloadWithFallback(r, "", func(context.Context, *function.Request, string) error {
+		t.Error("no node to try")
+		return nil
+	})
+	if !errors.Is(err, node.OutOfResourcesErr) || len(r.ExecReport.OffloadAttempts) != 0 {
+		t.Errorf("got %v (%d attempts)", err, len(r.ExecReport.OffloadAttempts))
+	}
+}
+
+func TestNewOffloadingRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		offloading      function.OffloadingInfo
+		canDoOffloading bool
+		maxRespT        float64
+		elapsed         time.Duration // since the arrival
+		hops            int
+		visited         []string
+		furtherOffload  bool
+		remainingRespT  float64
+	}{
+		{"first hop", function.OffloadingInfo{MaxHops: 2}, true, 0, 0,
+			1, []string{"http://self"}, true, 0},
+		{"max hops reached", function.OffloadingInfo{Hops: 1, MaxHops: 2, Visited: []string{"http://edge1"}}, true, 0, 0,
+			2, []string{"http://edge1", "http://self"}, false, 0},
+		{"offloading not allowed", function.OffloadingInfo{MaxHops: 2}, false, 0, 0,
+			1, []string{"http://self"}, false, 0},
+		{"remaining time", function.OffloadingInfo{MaxHops: 2}, true, 10, 4 * time.Second,
+			1, []string{"http://self"}, true, 6},
+		{"deadline expired", function.OffloadingInfo{MaxHops: 2}, true, 1, 2 * time.Second,
+			1, []string{"http://self"}, true, 0.001},
+		{"no deadline", function.OffloadingInfo{MaxHops: 2}, true, -1, 2 * time.Second,
+			1, []string{"http://self"}, true, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOffloadingTest(t, "")
+			c := &simClock{now: time.Unix(1000, 0)}
+			clock.Set(c)
+			t.Cleanup(func() { clock.Set(clock.Real()) })
+
+			r := newTestRequest(tt.canDoOffloading, c.Now().Add(-tt.elapsed))
+			r.MaxRespT = tt.maxRespT
+			r.Offloading = tt.offloading
+			visited := fmt.Sprint(tt.offloading.Visited)
+
+			req := newOffloadingRequest(r.Request, false)
+			e := req.Envelope
+			if e == nil || e.ReqId != r.ReqId || e.Hops != tt.hops || e.MaxHops != tt.offloading.MaxHops {
+				t.Fatalf("got envelope %+v", e)
+			}
+			if fmt.Sprint(e.Visited) != fmt.Sprint(tt.visited) {
+				t.Errorf("got visited %v, want %v", e.Visited, tt.visited)
+			}
+			if fmt.Sprint(r.Offloading.Visited) != visited {
+				t.Errorf("visited nodes of the request changed: %v", r.Offloading.Visited)
+			}
+			if req.CanDoOffloading != tt.furtherOffload {
+				t.Errorf("got CanDoOffloading %v, want %v", req.CanDoOffloading, tt.furtherOffload)
+			}
+			if math.Abs(e.RemainingRespT-tt.remainingRespT) > 1e-9 {
+				t.Errorf("got remaining response time %v, want %v", e.RemainingRespT, tt.remainingRespT)
+			}
+		})
+	}
+}
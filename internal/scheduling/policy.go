@@ -1,7 +1,86 @@
 package scheduling
 
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
 type Policy interface {
 	Init()
 	OnCompletion(request *scheduledRequest)
 	OnArrival(request *scheduledRequest)
 }
+
+// InspectablePolicy is implemented by policies whose internal state (e.g.,
+// what they have learned) can be inspected.
+type InspectablePolicy interface {
+	Policy
+	State() any
+}
+
+// offloadObserver is implemented by the policies that learn from the requests
+// served without a local container (e.g., offloaded ones): only these
+// policies are notified of such requests through OnCompletion.
+type offloadObserver interface {
+	Policy
+	observesOffloads()
+}
+
+// currentPolicy is the policy used by the scheduler
+var currentPolicy atomic.Value // policyInfo
+
+type policyInfo struct {
+	policy Policy
+	name   string
+}
+
+func setCurrentPolicy(p Policy) {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", p), "*scheduling.")
+	currentPolicy.Store(policyInfo{policy: p, name: name})
+}
+
+// policyObservesOffloads checks whether the policy used by the scheduler
+// learns from offloaded requests.
+func policyObservesOffloads() bool {
+	info, _ := currentPolicy.Load().(policyInfo)
+	_, ok := info.policy.(offloadObserver)
+	return ok
+}
+
+// currentPolicyName returns the name of the policy used by the scheduler.
+func currentPolicyName() string {
+	info, _ := currentPolicy.Load().(policyInfo)
+	return info.name
+}
+
+// PolicyState returns the internal state of the scheduling policy, if the
+// policy is inspectable.
+func PolicyState() (any, bool) {
+	info, _ := currentPolicy.Load().(policyInfo)
+	p, ok := info.policy.(InspectablePolicy)
+	if !ok {
+		return nil, false
+	}
+	return p.State(), true
+}
+
+// NewPolicy returns the scheduling policy with the given name (the default
+// one if the name is unknown).
+func NewPolicy(name string) Policy {
+	if name == "cloudonly" {
+		return &CloudOnlyPolicy{}
+	} else if name == "edgecloud" {
+		return &CloudEdgePolicy{}
+	} else if name == "edgeonly" {
+		return &EdgePolicy{}
+	} else if name == "custom1" {
+		return &Custom1Policy{}
+	} else if name == "qosaware" {
+		return &QoSAwarePolicy{}
+	} else if name == "learning" {
+		return &LearningPolicy{}
+	} else {
+		return &DefaultLocalPolicy{}
+	}
+}
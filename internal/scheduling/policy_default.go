@@ -3,39 +3,158 @@ package scheduling
 import (
 	"errors"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/grussorusso/serverledge/internal/clock"
 	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
 	"github.com/grussorusso/serverledge/internal/node"
 )
 
+// queueSweepInterval is the period of the check for queued requests that
+// should leave the queue
+const queueSweepInterval = 200 * time.Millisecond
+
+// durationAlpha is the smoothing factor of the execution time estimates
+const durationAlpha = 0.3
+
 type DefaultLocalPolicy struct {
-	queue queue
+	queue           queue
+	maxQueueingTime float64 // for requests without deadline (0 = no limit)
+	lowBestEffort   bool    // whether LOW class requests are served in best-effort mode
+
+	durationsMtx sync.Mutex
+	durations    map[string]float64 // estimated execution time of each function
 }
 
 func (p *DefaultLocalPolicy) Init() {
+	p.lowBestEffort = config.GetBool(config.SCHEDULER_BEST_EFFORT, false)
+
 	queueCapacity := config.GetInt(config.SCHEDULER_QUEUE_CAPACITY, 0)
 	if queueCapacity > 0 {
-		log.Printf("Configured queue with capacity %d\n", queueCapacity)
-		p.queue = NewFIFOQueue(queueCapacity)
+		queuePolicy := config.GetString(config.SCHEDULER_QUEUE_POLICY, "fifo")
+		newQueue := func(capacity int) queue {
+			if queuePolicy == "priority" {
+				return NewPriorityQueue(capacity)
+			}
+			return NewFIFOQueue(capacity)
+		}
+		if config.GetBool(config.SCHEDULER_QUEUE_FAIR, false) {
+			log.Printf("Configured per-function %s queues with total capacity %d\n", queuePolicy, queueCapacity)
+			p.queue = NewFairQueue(queueCapacity, config.GetFloatMap(config.SCHEDULER_QUEUE_WEIGHTS), newQueue)
+		} else {
+			log.Printf("Configured %s queue with capacity %d\n", queuePolicy, queueCapacity)
+			p.queue = newQueue(queueCapacity)
+		}
 	} else {
 		p.queue = nil
 	}
+
+	if p.queue != nil {
+		p.maxQueueingTime = config.GetFloat(config.SCHEDULER_QUEUE_MAX_WAIT, 0.0)
+		p.durations = make(map[string]float64)
+		clock.AfterFunc(queueSweepInterval, p.sweepQueue)
+	}
+}
+
+// sweepQueue removes from the queue the requests that cannot wait any
+// longer, and schedules the next check.
+func (p *DefaultLocalPolicy) sweepQueue() {
+	p.queue.Lock()
+	p.removeExpired(clock.Now())
+	p.queue.Unlock()
+
+	clock.AfterFunc(queueSweepInterval, p.sweepQueue)
+}
+
+// mustLeaveQueue checks whether waiting in the queue is still worth it for a
+// request, i.e., its deadline can be met after the estimated execution time
+// or, if the request has no deadline, it has not been waiting for longer
+// than maxQueueingTime.
+func (p *DefaultLocalPolicy) mustLeaveQueue(r *scheduledRequest, now time.Time) bool {
+	if r.abandoned() {
+		return true
+	}
+
+	deadline, ok := deadlineOf(r)
+	if !ok {
+		return p.maxQueueingTime > 0.0 && now.Sub(r.Arrival).Seconds() > p.maxQueueingTime
+	}
+
+	p.durationsMtx.Lock()
+	expectedDuration := p.durations[r.Fun.Name]
+	p.durationsMtx.Unlock()
+
+	return deadline.Sub(now).Seconds() <= expectedDuration
 }
 
-func (p *DefaultLocalPolicy) OnCompletion(_ *scheduledRequest) {
+// removeExpired removes the requests that cannot wait any longer, offloading
+// them to another node (the Cloud first, if any) if possible, and dropping
+// them otherwise. Abandoned requests are always dropped.
+// The queue must be locked by the caller.
+func (p *DefaultLocalPolicy) removeExpired(now time.Time) {
+	expired := p.queue.RemoveIf(func(r *scheduledRequest) bool {
+		return p.mustLeaveQueue(r, now)
+	})
+
+	for _, r := range expired {
+		deadline, hasDeadline := deadlineOf(r)
+		var candidates []string
+		if r.CanDoOffloading && !r.abandoned() && (!hasDeadline || deadline.After(now)) {
+			candidates = offloadingCandidates(r, remoteServerUrl)
+		}
+
+		if r.abandoned() {
+			log.Printf("[%s] Removing abandoned request from the queue\n", r)
+			r.note("abandoned by the client while queued")
+			dropExpiredRequest(r)
+		} else if len(candidates) > 0 {
+			log.Printf("[%s] Offloading request from the queue\n", r)
+			r.note("waited too long in the queue")
+			handleOffload(r, candidates[0])
+		} else {
+			log.Printf("[%s] Removing expired request from the queue\n", r)
+			r.note("waited too long in the queue")
+			dropExpiredRequest(r)
+		}
+	}
+}
+
+func (p *DefaultLocalPolicy) OnCompletion(completed *scheduledRequest) {
 	if p.queue == nil {
 		return
 	}
 
+	report := &completed.ExecReport
+	if report.ResponseTime > 0.0 && report.SchedAction != SCHED_ACTION_OFFLOAD {
+		p.durationsMtx.Lock()
+		p.durations[completed.Fun.Name] = ewma(p.durations[completed.Fun.Name], report.Duration, durationAlpha)
+		p.durationsMtx.Unlock()
+	}
+
 	p.queue.Lock()
 	defer p.queue.Unlock()
+	p.removeExpired(clock.Now())
 	if p.queue.Len() == 0 {
 		return
 	}
 
 	req := p.queue.Front()
 
-	containerID, err := node.AcquireWarmContainer(req.Fun)
+	if p.isBestEffort(req) {
+		if tryBestEffortExecution(req) {
+			p.queue.Dequeue()
+			log.Printf("[%s] Best-effort execution from the queue (length=%d)\n", req, p.queue.Len())
+			return
+		}
+		if !p.moveBestEffortBack() {
+			return
+		}
+		req = p.queue.Front()
+	}
+
+	containerID, err := acquireWarmContainer(req)
 	if err == nil {
 		p.queue.Dequeue()
 		log.Printf("[%s] Warm start from the queue (length=%d)\n", req, p.queue.Len())
@@ -44,21 +163,22 @@ func (p *DefaultLocalPolicy) OnCompletion(_ *scheduledRequest) {
 	}
 
 	if errors.Is(err, node.NoWarmFoundErr) {
-		if node.AcquireResources(req.Fun.CPUDemand, req.Fun.MemoryMB, true) {
+		if node.AcquireResources(req.Fun, true) {
 			log.Printf("[%s] Cold start from the queue\n", req)
 			p.queue.Dequeue()
 
 			// This avoids blocking the thread during the cold
 			// start, but also allows us to check for resource
 			// availability before dequeueing
-			go func() {
+			clock.AfterFunc(0, func() {
 				newContainer, err := node.NewContainerWithAcquiredResources(req.Fun)
 				if err != nil {
+					req.note("cold start failed: %v", err)
 					dropRequest(req)
 				} else {
 					execLocally(req, newContainer, false)
 				}
-			}()
+			})
 			return
 		}
 	} else if errors.Is(err, node.OutOfResourcesErr) {
@@ -70,7 +190,12 @@ func (p *DefaultLocalPolicy) OnCompletion(_ *scheduledRequest) {
 }
 
 func (p *DefaultLocalPolicy) OnArrival(r *scheduledRequest) {
-	containerID, err := node.AcquireWarmContainer(r.Fun)
+	if p.isBestEffort(r) {
+		p.onBestEffortArrival(r)
+		return
+	}
+
+	containerID, err := acquireWarmContainer(r)
 	if err == nil {
 		execLocally(r, containerID, true)
 		return
@@ -96,7 +221,85 @@ func (p *DefaultLocalPolicy) OnArrival(r *scheduledRequest) {
 			log.Printf("[%s] Added to queue (length=%d)\n", r, p.queue.Len())
 			return
 		}
+		if victim := p.preemptBestEffort(); victim != nil {
+			if p.queue.Enqueue(r) {
+				log.Printf("[%s] Added to queue preempting %s (length=%d)\n", r, victim, p.queue.Len())
+				victim.note("preempted in the queue by %s", r)
+				dropRequest(victim)
+				return
+			}
+			// no room for r anyway: restore the victim
+			p.queue.Enqueue(victim)
+		}
+		r.note("queue full")
 	}
 
 	dropRequest(r)
 }
+
+func (p *DefaultLocalPolicy) isBestEffort(r *scheduledRequest) bool {
+	return p.lowBestEffort && r.Class == function.LOW
+}
+
+// onBestEffortArrival serves a best-effort request if there are spare
+// resources and no other request is waiting, enqueueing it otherwise.
+// Best-effort requests never preempt other requests.
+func (p *DefaultLocalPolicy) onBestEffortArrival(r *scheduledRequest) {
+	if p.queue == nil {
+		if !tryBestEffortExecution(r) {
+			dropRequest(r)
+		}
+		return
+	}
+
+	// the queue is not held while trying the execution, as acquiring a warm
+	// container may check its health
+	p.queue.Lock()
+	waiting := p.queue.Len()
+	p.queue.Unlock()
+	if waiting == 0 && tryBestEffortExecution(r) {
+		return
+	}
+
+	p.queue.Lock()
+	defer p.queue.Unlock()
+	if p.queue.Enqueue(r) {
+		log.Printf("[%s] Added to queue as best-effort (length=%d)\n", r, p.queue.Len())
+		return
+	}
+
+	r.note("queue full")
+	dropRequest(r)
+}
+
+// moveBestEffortBack moves the best-effort requests behind the other ones in
+// the queue, so that they do not hold back requests waiting for resources.
+// It returns false if the queue only contains best-effort requests.
+// The queue must be locked by the caller.
+func (p *DefaultLocalPolicy) moveBestEffortBack() bool {
+	bestEffort := p.queue.RemoveIf(p.isBestEffort)
+	others := p.queue.Len()
+	for _, r := range bestEffort {
+		p.queue.Enqueue(r)
+	}
+	return others > 0
+}
+
+// preemptBestEffort removes a best-effort request from the queue (if any) and
+// returns it.
+// The queue must be locked by the caller.
+func (p *DefaultLocalPolicy) preemptBestEffort() *scheduledRequest {
+	if !p.lowBestEffort {
+		return nil
+	}
+
+	var victim *scheduledRequest
+	p.queue.RemoveIf(func(r *scheduledRequest) bool {
+		if victim == nil && p.isBestEffort(r) {
+			victim = r
+			return true
+		}
+		return false
+	})
+	return victim
+}
@@ -0,0 +1,70 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+// setupOffloadingTest makes the given nodes (with plenty of resources) nearby,
+// and cloud (if any) the Cloud node.
+func setupOffloadingTest(t *testing.T, cloud string, neighbours ...string) {
+	oldReg, oldRemote, oldSelf := registration.Reg, remoteServerUrl, selfUrl
+	t.Cleanup(func() {
+		registration.Reg, remoteServerUrl, selfUrl = oldReg, oldRemote, oldSelf
+	})
+
+	registration.Reg = &registration.Registry{NearbyServersMap: make(map[string]*registration.StatusInformation)}
+	for _, url := range neighbours {
+		registration.Reg.NearbyServersMap[url] = &registration.StatusInformation{Url: url, AvailableMemMB: 1024, AvailableCPUs: 4}
+	}
+	remoteServerUrl = cloud
+	selfUrl = "http://self"
+}
+
+func newTestRequest(canDoOffloading bool, arrival time.Time) *scheduledRequest {
+	f := &function.Function{Name: "fib", MemoryMB: 128, CPUDemand: 1}
+	return &scheduledRequest{
+		Request:         &function.Request{ReqId: "fib-1", Fun: f, Arrival: arrival, CanDoOffloading: canDoOffloading},
+		decisionChannel: make(chan schedDecision, 1),
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	tests := []struct {
+		name            string
+		cloud           string
+		neighbours      []string
+		canDoOffloading bool
+		action          action
+		remoteHost      string
+	}{
+		{"to the Cloud first", "http://cloud", []string{"http://edge1"}, true, EXEC_REMOTE, "http://cloud"},
+		{"to a nearby node without Cloud", "", []string{"http://edge1"}, true, EXEC_REMOTE, "http://edge1"},
+		{"nowhere to offload", "", nil, true, DROP_EXPIRED, ""},
+		{"offloading not allowed", "http://cloud", []string{"http://edge1"}, false, DROP_EXPIRED, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOffloadingTest(t, tt.cloud, tt.neighbours...)
+			now := time.Now()
+			p := &DefaultLocalPolicy{queue: NewFIFOQueue(10), maxQueueingTime: 1.0}
+			r := newTestRequest(tt.canDoOffloading, now.Add(-2*time.Second))
+			p.queue.Enqueue(r)
+
+			p.queue.Lock()
+			p.removeExpired(now)
+			p.queue.Unlock()
+
+			if p.queue.Len() != 0 {
+				t.Fatal("request still queued")
+			}
+			d := <-r.decisionChannel
+			if d.action != tt.action || d.remoteHost != tt.remoteHost {
+				t.Errorf("got action %v (%q), want %v (%q)", d.action, d.remoteHost, tt.action, tt.remoteHost)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package scheduling
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// PriorityQueue defines a bounded queue where requests are ordered by service
+// class first and then by absolute deadline (Earliest Deadline First).
+// Requests without a deadline come after those having one, and ties are
+// broken by arrival order.
+type PriorityQueue struct {
+	sync.Mutex
+	items    requestHeap
+	capacity int
+	seq      uint64
+}
+
+type priorityItem struct {
+	r        *scheduledRequest
+	deadline time.Time
+	seq      uint64
+}
+
+type requestHeap []priorityItem
+
+// NewPriorityQueue creates a queue
+func NewPriorityQueue(n int) *PriorityQueue {
+	if n < 1 {
+		return nil
+	}
+	return &PriorityQueue{
+		items:    make(requestHeap, 0, n),
+		capacity: n,
+	}
+}
+
+// classPriority maps service classes to queueing priorities (the higher, the
+// sooner a request is served).
+func classPriority(c function.ServiceClass) float64 {
+	switch c {
+	case function.HIGH_PERFORMANCE:
+		return 2.0
+	case function.HIGH_AVAILABILITY:
+		return 1.0
+	default:
+		return 0.0
+	}
+}
+
+// deadlineOf returns the absolute deadline of a request, if any.
+func deadlineOf(r *scheduledRequest) (time.Time, bool) {
+	if r.MaxRespT <= 0.0 {
+		return time.Time{}, false
+	}
+	return r.Arrival.Add(time.Duration(r.MaxRespT * float64(time.Second))), true
+}
+
+// IsEmpty returns true if queue is empty
+func (q *PriorityQueue) IsEmpty() bool {
+	return q != nil && len(q.items) == 0
+}
+
+// IsFull returns true if queue is full
+func (q *PriorityQueue) IsFull() bool {
+	return len(q.items) == q.capacity
+}
+
+// Enqueue inserts an element according to its priority
+func (q *PriorityQueue) Enqueue(r *scheduledRequest) bool {
+	if q.IsFull() {
+		return false
+	}
+
+	r.priority = classPriority(r.Class)
+	deadline, _ := deadlineOf(r)
+	heap.Push(&q.items, priorityItem{r: r, deadline: deadline, seq: q.seq})
+	q.seq++
+	return true
+}
+
+// Dequeue fetches the element with the highest priority
+func (q *PriorityQueue) Dequeue() *scheduledRequest {
+	if q.IsEmpty() {
+		return nil
+	}
+	return heap.Pop(&q.items).(priorityItem).r
+}
+
+// Front returns the element with the highest priority, without removing it
+func (q *PriorityQueue) Front() *scheduledRequest {
+	if q.IsEmpty() {
+		return nil
+	}
+	return q.items[0].r
+}
+
+// Len returns the current length of the queue
+func (q *PriorityQueue) Len() int {
+	return len(q.items)
+}
+
+// RemoveIf removes the elements satisfying cond and returns them
+func (q *PriorityQueue) RemoveIf(cond func(r *scheduledRequest) bool) []*scheduledRequest {
+	removed := make([]*scheduledRequest, 0)
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if cond(item.r) {
+			removed = append(removed, item.r)
+		} else {
+			kept = append(kept, item)
+		}
+	}
+	for i := len(kept); i < len(q.items); i++ {
+		q.items[i] = priorityItem{}
+	}
+	q.items = kept
+	heap.Init(&q.items)
+	return removed
+}
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.r.priority != b.r.priority {
+		return a.r.priority > b.r.priority
+	}
+	aHasDeadline := !a.deadline.IsZero()
+	bHasDeadline := !b.deadline.IsZero()
+	if aHasDeadline != bHasDeadline {
+		return aHasDeadline
+	}
+	if aHasDeadline && !a.deadline.Equal(b.deadline) {
+		return a.deadline.Before(b.deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (h requestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *requestHeap) Push(x any) {
+	*h = append(*h, x.(priorityItem))
+}
+
+func (h *requestHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = priorityItem{}
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,225 @@
+package scheduling
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+)
+
+// QoSAwarePolicy chooses, for every request, the execution site (local node,
+// nearby Edge node or Cloud) that is expected to meet the requested
+// maximum response time. Expectations are based on running estimates
+// collected from completed requests; sites with no estimate yet are only tried
+// when no other site is expected to meet the deadline.
+type QoSAwarePolicy struct {
+	sync.Mutex
+	alpha float64
+	stats map[string]*functionStats
+}
+
+// functionStats keeps exponentially weighted moving averages of the times
+// observed for a function. A zero value means that no sample is available yet.
+type functionStats struct {
+	duration      float64 // local execution time
+	coldInitTime  float64 // initialization time of a cold start
+	edgeRespTime  float64 // response time when offloaded to a nearby Edge node
+	cloudRespTime float64 // response time when offloaded to the Cloud
+}
+
+type executionSite int
+
+const (
+	siteLocal executionSite = iota
+	siteEdge
+	siteCloud
+)
+
+func (s executionSite) String() string {
+	switch s {
+	case siteLocal:
+		return "local"
+	case siteEdge:
+		return "edge"
+	default:
+		return "cloud"
+	}
+}
+
+type siteOption struct {
+	site     executionSite
+	estimate float64
+	known    bool // whether the estimate is based on samples
+	url      string
+}
+
+func (p *QoSAwarePolicy) Init() {
+	p.alpha = config.GetFloat(config.SCHEDULER_QOSAWARE_ALPHA, 0.3)
+	p.stats = make(map[string]*functionStats)
+}
+
+// observesOffloads makes the policy learn from offloaded requests too.
+func (p *QoSAwarePolicy) observesOffloads() {}
+
+func (p *QoSAwarePolicy) OnCompletion(r *scheduledRequest) {
+	report := &r.ExecReport
+	if report.ResponseTime <= 0.0 {
+		// the request failed: nothing to learn
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	s := p.getStats(r.Fun)
+	if report.SchedAction == SCHED_ACTION_OFFLOAD {
+		respTime := report.OffloadLatency + report.InitTime + report.Duration
+		if r.remoteHost == remoteServerUrl {
+			s.cloudRespTime = ewma(s.cloudRespTime, respTime, p.alpha)
+		} else {
+			s.edgeRespTime = ewma(s.edgeRespTime, respTime, p.alpha)
+		}
+		return
+	}
+
+	s.duration = ewma(s.duration, report.Duration, p.alpha)
+	if !report.IsWarmStart {
+		s.coldInitTime = ewma(s.coldInitTime, report.InitTime, p.alpha)
+	}
+}
+
+func (p *QoSAwarePolicy) OnArrival(r *scheduledRequest) {
+	if r.MaxRespT <= 0.0 {
+		// no deadline to honour
+		p.executeAnywhere(r)
+		return
+	}
+
+	// the time spent so far counts against the deadline
+	budget := r.MaxRespT - clock.Now().Sub(r.Arrival).Seconds()
+
+	unknown := make([]siteOption, 0)
+	for _, opt := range p.rankOptions(r) {
+		if !opt.known {
+			unknown = append(unknown, opt)
+			continue
+		}
+		if opt.estimate > budget {
+			r.note("%s: estimated response time %.3f s exceeds the remaining %.3f s", opt.site, opt.estimate, budget)
+			continue
+		}
+		if p.tryOption(r, opt) {
+			return
+		}
+	}
+	for _, opt := range unknown {
+		if p.tryOption(r, opt) {
+			return
+		}
+	}
+
+	if r.Class == function.HIGH_AVAILABILITY {
+		// availability matters more than the deadline for this class
+		p.executeAnywhere(r)
+		return
+	}
+
+	log.Printf("[%s] Deadline cannot be met: dropping\n", r)
+	dropRequest(r)
+}
+
+// rankOptions returns the candidate execution sites for a request, along
+// with their estimated response times. HIGH_PERFORMANCE requests get the
+// fastest sites first (sites with no estimate last), while for the other
+// classes local execution is preferred over Edge offloading, and Edge
+// offloading over the Cloud.
+func (p *QoSAwarePolicy) rankOptions(r *scheduledRequest) []siteOption {
+	p.Lock()
+	s := *p.getStats(r.Fun)
+	p.Unlock()
+
+	options := make([]siteOption, 0, 3)
+
+	local := siteOption{site: siteLocal, estimate: s.duration, known: s.duration > 0.0}
+	if node.WarmStatus()[r.Fun.Name] < 1 {
+		local.estimate += s.coldInitTime
+		local.known = local.known && s.coldInitTime > 0.0
+	}
+	options = append(options, local)
+
+	if r.CanDoOffloading {
+		if url := pickEdgeNodeForOffloading(r); url != "" {
+			options = append(options, siteOption{site: siteEdge, estimate: s.edgeRespTime, known: s.edgeRespTime > 0.0, url: url})
+		}
+		if remoteServerUrl != "" {
+			options = append(options, siteOption{site: siteCloud, estimate: s.cloudRespTime, known: s.cloudRespTime > 0.0, url: remoteServerUrl})
+		}
+	}
+
+	if r.Class == function.HIGH_PERFORMANCE {
+		sort.SliceStable(options, func(i, j int) bool {
+			if options[i].known != options[j].known {
+				return options[i].known
+			}
+			return options[i].estimate < options[j].estimate
+		})
+	}
+
+	return options
+}
+
+// tryOption attempts to serve the request on the given site, returning
+// false if the site turned out to be unavailable.
+func (p *QoSAwarePolicy) tryOption(r *scheduledRequest, opt siteOption) bool {
+	switch opt.site {
+	case siteLocal:
+		containerID, err := acquireWarmContainer(r)
+		if err == nil {
+			execLocally(r, containerID, true)
+			return true
+		}
+		return handleColdStart(r)
+	default:
+		handleOffload(r, opt.url)
+		return true
+	}
+}
+
+// executeAnywhere serves a request regardless of its deadline, trying
+// local execution first and then offloading to the Cloud.
+func (p *QoSAwarePolicy) executeAnywhere(r *scheduledRequest) {
+	containerID, err := acquireWarmContainer(r)
+	if err == nil {
+		execLocally(r, containerID, true)
+	} else if handleColdStart(r) {
+		return
+	} else if r.CanDoOffloading && remoteServerUrl != "" {
+		handleCloudOffload(r)
+	} else {
+		dropRequest(r)
+	}
+}
+
+// getStats retrieves (or creates) the statistics of a function.
+// The function is NOT thread-safe.
+func (p *QoSAwarePolicy) getStats(f *function.Function) *functionStats {
+	s, ok := p.stats[f.Name]
+	if !ok {
+		s = &functionStats{}
+		p.stats[f.Name] = s
+	}
+	return s
+}
+
+// ewma returns the updated value of an exponentially weighted moving average
+// (0.0 means that no sample has been observed yet).
+func ewma(oldValue, sample, alpha float64) float64 {
+	if oldValue == 0.0 {
+		return sample
+	}
+	return alpha*sample + (1.0-alpha)*oldValue
+}
@@ -0,0 +1,102 @@
+package scheduling
+
+import (
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+)
+
+func newTestQoSAwarePolicy(stats functionStats) *QoSAwarePolicy {
+	p := &QoSAwarePolicy{alpha: 0.5, stats: make(map[string]*functionStats)}
+	p.stats["qosTest"] = &stats
+	return p
+}
+
+func newTestQoSRequest(class function.ServiceClass, maxRespT float64, canDoOffloading bool) *scheduledRequest {
+	f := &function.Function{Name: "qosTest", MemoryMB: 128, CPUDemand: 1}
+	rq := &function.Request{ReqId: "qosTest-1", Fun: f, Arrival: clock.Now(), CanDoOffloading: canDoOffloading}
+	rq.Class = class
+	rq.MaxRespT = maxRespT
+	return &scheduledRequest{Request: rq, decisionChannel: make(chan schedDecision, 1)}
+}
+
+func TestQoSAwareRankOptions(t *testing.T) {
+	withOffloadingTargets(t)
+	node.InitResources(0, 0) // no warm containers
+
+	tests := []struct {
+		name            string
+		class           function.ServiceClass
+		canDoOffloading bool
+		stats           functionStats
+		want            []executionSite
+	}{
+		{"fastest first", function.HIGH_PERFORMANCE, true,
+			functionStats{duration: 0.5, coldInitTime: 0.5, edgeRespTime: 0.3, cloudRespTime: 0.6},
+			[]executionSite{siteEdge, siteCloud, siteLocal}},
+		{"unknown last", function.HIGH_PERFORMANCE, true,
+			functionStats{duration: 0.5, coldInitTime: 0.5, cloudRespTime: 0.2},
+			[]executionSite{siteCloud, siteLocal, siteEdge}},
+		{"cold start time unknown", function.HIGH_PERFORMANCE, true,
+			functionStats{duration: 0.1, edgeRespTime: 0.3, cloudRespTime: 0.6},
+			[]executionSite{siteEdge, siteCloud, siteLocal}},
+		{"nothing known", function.HIGH_PERFORMANCE, true,
+			functionStats{},
+			[]executionSite{siteLocal, siteEdge, siteCloud}},
+		{"closest first", function.LOW, true,
+			functionStats{duration: 0.5, coldInitTime: 0.5, edgeRespTime: 0.3, cloudRespTime: 0.2},
+			[]executionSite{siteLocal, siteEdge, siteCloud}},
+		{"no offloading", function.HIGH_PERFORMANCE, false,
+			functionStats{duration: 0.5, coldInitTime: 0.5, edgeRespTime: 0.3, cloudRespTime: 0.2},
+			[]executionSite{siteLocal}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestQoSAwarePolicy(tt.stats)
+			r := newTestQoSRequest(tt.class, 1.0, tt.canDoOffloading)
+
+			options := p.rankOptions(r)
+			if len(options) != len(tt.want) {
+				t.Fatalf("got %d options, want %d", len(options), len(tt.want))
+			}
+			for i, opt := range options {
+				if opt.site != tt.want[i] {
+					t.Errorf("option %d: got %s, want %s", i, opt.site, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQoSAwareDeadlineMissed(t *testing.T) {
+	withOffloadingTargets(t)
+	node.InitResources(0, 0) // no local execution
+
+	// every site is expected to miss the deadline
+	stats := functionStats{duration: 1.0, coldInitTime: 1.0, edgeRespTime: 1.5, cloudRespTime: 2.0}
+	tests := []struct {
+		name       string
+		class      function.ServiceClass
+		maxRespT   float64
+		action     action
+		remoteHost string
+	}{
+		{"dropped", function.HIGH_PERFORMANCE, 1.0, DROP, ""},
+		{"served anywhere", function.HIGH_AVAILABILITY, 1.0, EXEC_REMOTE, testCloudUrl},
+		{"no deadline", function.HIGH_PERFORMANCE, 0.0, EXEC_REMOTE, testCloudUrl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestQoSAwarePolicy(stats)
+			r := newTestQoSRequest(tt.class, tt.maxRespT, true)
+
+			p.OnArrival(r)
+			d := <-r.decisionChannel
+			if d.action != tt.action || d.remoteHost != tt.remoteHost {
+				t.Errorf("got action %v (%q), want %v (%q)", d.action, d.remoteHost, tt.action, tt.remoteHost)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ type queue interface {
 	Dequeue() *scheduledRequest
 	Front() *scheduledRequest
 	Len() int
+	RemoveIf(cond func(r *scheduledRequest) bool) []*scheduledRequest
 	Lock()
 	Unlock()
 }
@@ -80,3 +81,25 @@ func (q *FIFOQueue) Front() *scheduledRequest {
 func (q *FIFOQueue) Len() int {
 	return q.size
 }
+
+// RemoveIf removes the elements satisfying cond, preserving the order of the
+// remaining ones, and returns the removed elements
+func (q *FIFOQueue) RemoveIf(cond func(r *scheduledRequest) bool) []*scheduledRequest {
+	removed := make([]*scheduledRequest, 0)
+	kept := 0
+	for i := 0; i < q.size; i++ {
+		v := q.data[(q.head+i)%q.capacity]
+		if cond(v) {
+			removed = append(removed, v)
+		} else {
+			q.data[(q.head+kept)%q.capacity] = v
+			kept++
+		}
+	}
+	for i := kept; i < q.size; i++ {
+		q.data[(q.head+i)%q.capacity] = nil
+	}
+	q.size = kept
+	q.tail = (q.head + kept) % q.capacity
+	return removed
+}
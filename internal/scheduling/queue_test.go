@@ -3,6 +3,7 @@ package scheduling
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/grussorusso/serverledge/internal/function"
 )
@@ -19,3 +20,154 @@ func TestQueuet(t *testing.T) {
 	q.Enqueue(r1)
 	fmt.Printf("Size = %d\n", q.Len())
 }
+
+func TestPriorityQueue(t *testing.T) {
+	f := function.Function{Name: "Function1"}
+	now := time.Now()
+	newRequest := func(class function.ServiceClass, maxRespT float64, arrival time.Time) *scheduledRequest {
+		rq := &function.Request{Fun: &f, Arrival: arrival}
+		rq.Class = class
+		rq.MaxRespT = maxRespT
+		return &scheduledRequest{Request: rq}
+	}
+
+	lowNoDeadline := newRequest(function.LOW, 0, now)
+	lowLate := newRequest(function.LOW, 10, now)
+	lowEarly := newRequest(function.LOW, 1, now.Add(time.Second))
+	perf := newRequest(function.HIGH_PERFORMANCE, 0, now.Add(2*time.Second))
+
+	q := NewPriorityQueue(4)
+	for _, r := range []*scheduledRequest{lowNoDeadline, lowLate, lowEarly, perf} {
+		if !q.Enqueue(r) {
+			t.Fatalf("Enqueue failed with length %d", q.Len())
+		}
+	}
+	if q.Enqueue(perf) {
+		t.Errorf("Enqueue succeeded on a full queue")
+	}
+
+	expected := []*scheduledRequest{perf, lowEarly, lowLate, lowNoDeadline}
+	for i, e := range expected {
+		if q.Front() != e {
+			t.Errorf("Unexpected front at position %d", i)
+		}
+		if r := q.Dequeue(); r != e {
+			t.Errorf("Unexpected request at position %d", i)
+		}
+	}
+	if q.Len() != 0 || q.Dequeue() != nil {
+		t.Errorf("Queue should be empty")
+	}
+}
+
+func TestQueueRemoveIf(t *testing.T) {
+	f := function.Function{Name: "Function1"}
+	requests := make([]*scheduledRequest, 5)
+	for i := range requests {
+		rq := &function.Request{Fun: &f, ReqId: fmt.Sprintf("%d", i)}
+		requests[i] = &scheduledRequest{Request: rq}
+	}
+	isOdd := func(r *scheduledRequest) bool { return r.ReqId == "1" || r.ReqId == "3" }
+
+	// make the circular buffer wrap around
+	q := NewFIFOQueue(4)
+	q.Enqueue(requests[0])
+	q.Enqueue(requests[0])
+	q.Dequeue()
+	q.Dequeue()
+	for _, r := range requests[:4] {
+		q.Enqueue(r)
+	}
+
+	removed := q.RemoveIf(isOdd)
+	if len(removed) != 2 || q.Len() != 2 {
+		t.Fatalf("Removed %d requests, %d left", len(removed), q.Len())
+	}
+	if q.Dequeue() != requests[0] || q.Dequeue() != requests[2] {
+		t.Errorf("Unexpected order after removal")
+	}
+	if !q.Enqueue(requests[4]) || q.Front() != requests[4] {
+		t.Errorf("Enqueue after removal failed")
+	}
+
+	pq := NewPriorityQueue(4)
+	for _, r := range requests[:4] {
+		pq.Enqueue(r)
+	}
+	removed = pq.RemoveIf(isOdd)
+	if len(removed) != 2 || pq.Len() != 2 {
+		t.Fatalf("Removed %d requests, %d left", len(removed), pq.Len())
+	}
+	if pq.Dequeue() != requests[0] || pq.Dequeue() != requests[2] {
+		t.Errorf("Unexpected order after removal")
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	f1 := function.Function{Name: "Noisy"}
+	f2 := function.Function{Name: "Quiet"}
+	newFIFO := func(capacity int) queue { return NewFIFOQueue(capacity) }
+
+	q := NewFairQueue(10, map[string]float64{"noisy": 2.0}, newFIFO)
+	for i := 0; i < 6; i++ {
+		q.Enqueue(&scheduledRequest{Request: &function.Request{Fun: &f1}})
+	}
+	for i := 0; i < 2; i++ {
+		q.Enqueue(&scheduledRequest{Request: &function.Request{Fun: &f2}})
+	}
+	if q.Len() != 8 {
+		t.Fatalf("Unexpected length: %d", q.Len())
+	}
+
+	expected := []string{"Noisy", "Noisy", "Quiet", "Noisy", "Noisy", "Quiet", "Noisy", "Noisy"}
+	for i, name := range expected {
+		front := q.Front()
+		r := q.Dequeue()
+		if front != r {
+			t.Errorf("Front and Dequeue disagree at position %d", i)
+		}
+		if r.Fun.Name != name {
+			t.Errorf("Expected %s at position %d, got %s", name, i, r.Fun.Name)
+		}
+	}
+	if q.Len() != 0 || q.Dequeue() != nil {
+		t.Errorf("Queue should be empty")
+	}
+}
+
+func TestFairQueueCapacity(t *testing.T) {
+	f1 := function.Function{Name: "Noisy"}
+	f2 := function.Function{Name: "Quiet"}
+	newFIFO := func(capacity int) queue { return NewFIFOQueue(capacity) }
+
+	// the capacity is shared by the functions
+	q := NewFairQueue(3, nil, newFIFO)
+	requests := []*scheduledRequest{
+		{Request: &function.Request{Fun: &f1}},
+		{Request: &function.Request{Fun: &f1}},
+		{Request: &function.Request{Fun: &f2}},
+		{Request: &function.Request{Fun: &f2}},
+	}
+	for i, r := range requests {
+		if q.Enqueue(r) != (i < 3) {
+			t.Errorf("Unexpected outcome enqueueing request %d", i)
+		}
+	}
+	if q.Len() != 3 {
+		t.Fatalf("Unexpected length: %d", q.Len())
+	}
+
+	// empty flows are forgotten
+	q.RemoveIf(func(r *scheduledRequest) bool { return r.Fun == &f2 })
+	if _, ok := q.flows["Quiet"]; ok || len(q.flows) != 1 {
+		t.Errorf("Flow of an empty queue kept: %d flows", len(q.flows))
+	}
+	for q.Dequeue() != nil {
+	}
+	if len(q.flows) != 0 || len(q.active) != 0 {
+		t.Errorf("Flows kept after emptying the queue: %d", len(q.flows))
+	}
+	if !q.Enqueue(requests[3]) || q.Front() != requests[3] {
+		t.Errorf("Cannot enqueue again")
+	}
+}
@@ -1,13 +1,16 @@
 package scheduling
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime"
+	"sync/atomic"
 	"time"
 
+	"github.com/grussorusso/serverledge/internal/clock"
 	"github.com/grussorusso/serverledge/internal/metrics"
 	"github.com/grussorusso/serverledge/internal/node"
 
@@ -15,32 +18,63 @@ import (
 
 	"github.com/grussorusso/serverledge/internal/container"
 	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/utils"
 )
 
 var requests chan *scheduledRequest
 var completions chan *completion
 
 var remoteServerUrl string
+var selfUrl string
 var executionLogEnabled bool
 
 var offloadingClient *http.Client
 
-func Run(p Policy) {
+// containersReady is set once containers can be created (e.g., for the
+// functions requiring warm containers at all times)
+var containersReady atomic.Bool
+
+// SCHED_ACTION_BEST_EFFORT marks requests served in best-effort mode
+const SCHED_ACTION_BEST_EFFORT = "B"
+
+// DeadlineExceededErr is returned for requests that have been dropped because
+// they could not be served within their deadline
+var DeadlineExceededErr = errors.New("request deadline exceeded")
+
+// ExecutionTimeoutErr is returned when the function has been killed for
+// exceeding its timeout
+var ExecutionTimeoutErr = errors.New("function execution timed out")
+
+// Init initializes the resources of the node, and adopts the containers left
+// by a previous run. It must be called before serving any request (and
+// before Run), so that the adopted containers are accounted for first.
+func Init() {
 	requests = make(chan *scheduledRequest, 500)
 	completions = make(chan *completion, 500)
 
 	// initialize Resources resources
 	availableCores := runtime.NumCPU()
-	node.Resources.AvailableMemMB = int64(config.GetInt(config.POOL_MEMORY_MB, 1024))
-	node.Resources.AvailableCPUs = config.GetFloat(config.POOL_CPUS, float64(availableCores))
-	node.Resources.ContainerPools = make(map[string]*node.ContainerPool)
+	node.InitResources(config.GetFloat(config.POOL_CPUS, float64(availableCores)),
+		int64(config.GetInt(config.POOL_MEMORY_MB, 1024)))
 	log.Printf("Current resources: %v\n", &node.Resources)
+	registerFunctionsWithReservations()
 
 	container.InitDockerContainerFactory()
+	node.AdoptOrphanedContainers()
+	containersReady.Store(true)
+}
 
+// Run starts the scheduler, which handles the requests submitted through
+// SubmitRequest and SubmitAsyncRequest with the given policy. Init must have
+// been called.
+func Run(p Policy) {
 	//janitor periodically remove expired warm container
 	node.GetJanitorInstance()
 
+	if node.InitAutoscaler() {
+		node.StartAutoscaler()
+	}
+
 	tr := &http.Transport{
 		MaxIdleConns:        2500,
 		MaxIdleConnsPerHost: 2500,
@@ -49,45 +83,123 @@ func Run(p Policy) {
 	}
 	offloadingClient = &http.Client{Transport: tr}
 
+	remoteServerUrl = config.GetString(config.CLOUD_URL, "")
+	selfUrl = fmt.Sprintf("http://%s:%d", utils.GetIpAddress().String(), config.GetInt(config.API_PORT, 1323))
+	maxOffloadAttempts = config.GetInt(config.SCHEDULER_OFFLOAD_ATTEMPTS, 3)
+
+	initDecisionLog()
+	initWarmRouting()
+
 	// initialize scheduling policy
 	p.Init()
-
-	remoteServerUrl = config.GetString(config.CLOUD_URL, "")
+	setCurrentPolicy(p)
 
 	log.Println("Scheduler started.")
 
+	// arrivals and completions are handled concurrently: the container pools
+	// and the policies take care of synchronization
 	var r *scheduledRequest
 	var c *completion
 	for {
 		select {
 		case r = <-requests:
-			go p.OnArrival(r)
-		case c = <-completions:
-			node.ReleaseContainer(c.contID, c.Fun)
-			p.OnCompletion(c.scheduledRequest)
-
-			if metrics.Enabled {
-				metrics.AddCompletedInvocation(c.Fun.Name)
-				if c.ExecReport.SchedAction != SCHED_ACTION_OFFLOAD {
-					metrics.AddFunctionDurationValue(c.Fun.Name, c.ExecReport.Duration)
-				}
+			if draining.Load() {
+				go refuseWhileDraining(r)
+			} else {
+				go p.OnArrival(r)
 			}
+		case c = <-completions:
+			go handleCompletion(p, c)
+		}
+	}
+
+}
+
+// handleCompletion releases the container used by a completed request, and
+// notifies the policy.
+func handleCompletion(p Policy, c *completion) {
+	if c.contID == "" {
+		// served elsewhere (e.g., offloaded)
+		notifyRemoteCompletion(p, c.scheduledRequest)
+		return
+	}
+
+	if c.discardContainer {
+		node.DestroyContainer(c.contID, c.Fun)
+	} else {
+		node.ReleaseContainer(c.contID, c.Fun)
+	}
+	p.OnCompletion(c.scheduledRequest)
+	observeExecution(c.scheduledRequest)
+
+	if metrics.Enabled {
+		metrics.AddCompletedInvocation(c.Fun.Name)
+		if c.ExecReport.SchedAction != SCHED_ACTION_OFFLOAD {
+			metrics.AddFunctionDurationValue(c.Fun.Name, c.ExecReport.Duration)
 		}
 	}
+}
+
+// notifyRemoteCompletion notifies the policy of a request served without a
+// local container (e.g., offloaded), if the policy learns from such requests.
+func notifyRemoteCompletion(p Policy, r *scheduledRequest) {
+	if o, ok := p.(offloadObserver); ok {
+		o.OnCompletion(r)
+	}
+}
+
+// notifyOffloadFailure notifies the scheduler of a request that could not be
+// offloaded, unless the client has gone away.
+func notifyOffloadFailure(r *scheduledRequest) {
+	if errors.Is(r.ctx.Err(), context.Canceled) {
+		return
+	}
+	r.failed = true
+	completions <- newCompletion(r, "", false)
+}
 
+// registerFunctionsWithReservations makes the node aware of the functions
+// reserving resources, so that other functions cannot take them, or requiring
+// warm containers at all times. Functions created or deleted afterwards (on
+// any node) are registered or unregistered as well, and their warm containers
+// created right away (rather than by the next janitor round).
+func registerFunctionsWithReservations() {
+	function.Watch(func(f *function.Function) {
+		if f.ReservedCPUs > 0.0 || f.ReservedMemMB > 0 || f.MinWarm > 0 {
+			node.RegisterFunction(f)
+		}
+		if f.MinWarm > 0 && containersReady.Load() {
+			go node.KeepMinWarm(f)
+		}
+	}, node.UnregisterFunction)
 }
 
-// SubmitRequest submits a newly arrived request for scheduling and execution
-func SubmitRequest(r *function.Request) error {
+// SubmitRequest submits a newly arrived request for scheduling and execution.
+// If ctx is done before the request is served, the request is abandoned as
+// soon as possible (e.g., removed from the queue, or aborted while running).
+// If the request cannot be offloaded, the error is an *OffloadErr listing the
+// nodes tried.
+func SubmitRequest(ctx context.Context, r *function.Request) error {
+	inFlight.Add(1)
+	defer inFlight.Add(-1)
+
 	schedRequest := scheduledRequest{
 		Request:         r,
+		ctx:             ctx,
 		decisionChannel: make(chan schedDecision, 1)}
 	requests <- &schedRequest
 
 	// wait on channel for scheduling action
-	schedDecision, ok := <-schedRequest.decisionChannel
-	if !ok {
-		return fmt.Errorf("could not schedule the request")
+	var schedDecision schedDecision
+	var ok bool
+	select {
+	case schedDecision, ok = <-schedRequest.decisionChannel:
+		if !ok {
+			return fmt.Errorf("could not schedule the request")
+		}
+	case <-ctx.Done():
+		go discardDecision(&schedRequest)
+		return requestCtxErr(ctx)
 	}
 	//log.Printf("[%s] Scheduling decision: %v", r, schedDecision)
 
@@ -95,15 +207,33 @@ func SubmitRequest(r *function.Request) error {
 	if schedDecision.action == DROP {
 		//log.Printf("[%s] Dropping request", r)
 		return node.OutOfResourcesErr
+	} else if schedDecision.action == DROP_EXPIRED {
+		return DeadlineExceededErr
+	} else if schedDecision.action == DROP_DRAINING {
+		return NodeDrainingErr
 	} else if schedDecision.action == EXEC_REMOTE {
 		//log.Printf("Offloading request")
-		err = Offload(r, schedDecision.remoteHost)
+		err = offloadWithFallback(&schedRequest, schedDecision.remoteHost, Offload)
 		if err != nil {
+			attempts := r.ExecReport.OffloadAttempts
+			notifyOffloadFailure(&schedRequest)
+			if ctx.Err() != nil {
+				err = requestCtxErr(ctx)
+			}
+			if len(attempts) > 0 {
+				// the client is told which nodes have been tried
+				return &OffloadErr{Attempts: attempts, Err: err}
+			}
 			return err
 		}
+		// notify scheduler (no container to release)
+		completions <- newCompletion(&schedRequest, "", false)
 	} else {
 		err = Execute(schedDecision.contID, &schedRequest)
 		if err != nil {
+			if ctx.Err() != nil {
+				return requestCtxErr(ctx)
+			}
 			return err
 		}
 	}
@@ -111,43 +241,97 @@ func SubmitRequest(r *function.Request) error {
 }
 
 // SubmitAsyncRequest submits a newly arrived async request for scheduling and execution
-func SubmitAsyncRequest(r *function.Request) {
+func SubmitAsyncRequest(ctx context.Context, r *function.Request) {
+	inFlight.Add(1)
+	defer inFlight.Add(-1)
+
 	schedRequest := scheduledRequest{
 		Request:         r,
+		ctx:             ctx,
 		decisionChannel: make(chan schedDecision, 1)}
 	requests <- &schedRequest
 
 	// wait on channel for scheduling action
-	schedDecision, ok := <-schedRequest.decisionChannel
-	if !ok {
+	var schedDecision schedDecision
+	var ok bool
+	select {
+	case schedDecision, ok = <-schedRequest.decisionChannel:
+		if !ok {
+			publishAsyncResponse(r.ReqId, function.Response{Success: false})
+			return
+		}
+	case <-ctx.Done():
+		go discardDecision(&schedRequest)
 		publishAsyncResponse(r.ReqId, function.Response{Success: false})
 		return
 	}
 
 	var err error
-	if schedDecision.action == DROP {
+	if schedDecision.action == DROP || schedDecision.action == DROP_EXPIRED || schedDecision.action == DROP_DRAINING {
 		publishAsyncResponse(r.ReqId, function.Response{Success: false})
 	} else if schedDecision.action == EXEC_REMOTE {
 		//log.Printf("Offloading request")
-		err = OffloadAsync(r, schedDecision.remoteHost)
+		err = offloadWithFallback(&schedRequest, schedDecision.remoteHost, OffloadAsync)
 		if err != nil {
-			publishAsyncResponse(r.ReqId, function.Response{Success: false})
+			report := function.ExecutionReport{OffloadAttempts: r.ExecReport.OffloadAttempts}
+			publishAsyncResponse(r.ReqId, function.Response{Success: false, ExecutionReport: report})
+			notifyOffloadFailure(&schedRequest)
+		} else if policyObservesOffloads() {
+			go completeAsyncOffload(&schedRequest)
 		}
 	} else {
 		err = Execute(schedDecision.contID, &schedRequest)
 		if err != nil {
 			publishAsyncResponse(r.ReqId, function.Response{Success: false})
+			return
 		}
 		publishAsyncResponse(r.ReqId, function.Response{Success: true, ExecutionReport: r.ExecReport})
 	}
 }
 
+// discardDecision waits for the decision about an abandoned request, and
+// releases the container possibly acquired for it.
+func discardDecision(r *scheduledRequest) {
+	decision, ok := <-r.decisionChannel
+	if !ok {
+		return
+	}
+	if decision.action == EXEC_LOCAL || decision.action == BEST_EFFORT_EXECUTION {
+		completions <- newCompletion(r, decision.contID, false)
+	}
+}
+
+// requestCtxErr returns the error to report for a request whose context is
+// done.
+func requestCtxErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return DeadlineExceededErr
+	}
+	return ctx.Err()
+}
+
+// acquireWarmContainer is like node.AcquireWarmContainer, but notes why no
+// warm container could be used for the request.
+func acquireWarmContainer(r *scheduledRequest) (container.ContainerID, error) {
+	containerID, err := node.AcquireWarmContainer(r.Fun)
+	if err != nil {
+		r.note("warm start: %v", err)
+	}
+	return containerID, err
+}
+
 func handleColdStart(r *scheduledRequest) (isSuccess bool) {
+	if tryWarmRouting(r) {
+		return true
+	}
+
 	newContainer, err := node.NewContainer(r.Fun)
 	if errors.Is(err, node.OutOfResourcesErr) {
+		r.note("cold start: %v", err)
 		return false
 	} else if err != nil {
 		log.Printf("Cold start failed: %v\n", err)
+		r.note("cold start failed: %v", err)
 		return false
 	} else {
 		execLocally(r, newContainer, false)
@@ -155,29 +339,80 @@ func handleColdStart(r *scheduledRequest) (isSuccess bool) {
 	}
 }
 
+// sendDecision notifies the decision about a request, recording it in the
+// decision log.
+func sendDecision(r *scheduledRequest, decision schedDecision) {
+	logDecision(r, decision)
+	r.decisionChannel <- decision
+}
+
 func dropRequest(r *scheduledRequest) {
-	r.decisionChannel <- schedDecision{action: DROP}
+	sendDecision(r, schedDecision{action: DROP})
+}
+
+func dropExpiredRequest(r *scheduledRequest) {
+	sendDecision(r, schedDecision{action: DROP_EXPIRED})
 }
 
 func execLocally(r *scheduledRequest, c container.ContainerID, warmStart bool) {
-	initTime := time.Now().Sub(r.Arrival).Seconds()
+	initTime := clock.Now().Sub(r.Arrival).Seconds()
 	r.ExecReport.InitTime = initTime
 	r.ExecReport.IsWarmStart = warmStart
 
 	decision := schedDecision{action: EXEC_LOCAL, contID: c}
-	r.decisionChannel <- decision
+	sendDecision(r, decision)
+}
+
+// execBestEffort is like execLocally, but marks the request as served in
+// best-effort mode.
+func execBestEffort(r *scheduledRequest, c container.ContainerID, warmStart bool) {
+	initTime := clock.Now().Sub(r.Arrival).Seconds()
+	r.ExecReport.InitTime = initTime
+	r.ExecReport.IsWarmStart = warmStart
+	r.ExecReport.SchedAction = SCHED_ACTION_BEST_EFFORT
+
+	decision := schedDecision{action: BEST_EFFORT_EXECUTION, contID: c}
+	sendDecision(r, decision)
+}
+
+// tryBestEffortExecution serves a request using spare resources only, i.e.,
+// without dismissing warm containers of other functions. The cold start (if
+// needed) happens asynchronously.
+func tryBestEffortExecution(r *scheduledRequest) bool {
+	containerID, err := node.AcquireWarmContainer(r.Fun)
+	if err == nil {
+		execBestEffort(r, containerID, true)
+		return true
+	}
+	if !errors.Is(err, node.NoWarmFoundErr) {
+		return false
+	}
+	if !node.AcquireResources(r.Fun, false) {
+		r.note("best-effort cold start: no spare resources")
+		return false
+	}
+
+	clock.AfterFunc(0, func() {
+		newContainer, err := node.NewContainerWithAcquiredResources(r.Fun)
+		if err != nil {
+			r.note("cold start failed: %v", err)
+			dropRequest(r)
+		} else {
+			execBestEffort(r, newContainer, false)
+		}
+	})
+	return true
 }
 
 func handleOffload(r *scheduledRequest, serverHost string) {
-	r.CanDoOffloading = false // the next server can't offload this request
-	r.decisionChannel <- schedDecision{
+	r.remoteHost = serverHost
+	sendDecision(r, schedDecision{
 		action:     EXEC_REMOTE,
 		contID:     "",
 		remoteHost: serverHost,
-	}
+	})
 }
 
 func handleCloudOffload(r *scheduledRequest) {
-	cloudAddress := config.GetString(config.CLOUD_URL, "")
-	handleOffload(r, cloudAddress)
+	handleOffload(r, remoteServerUrl)
 }
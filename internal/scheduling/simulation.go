@@ -0,0 +1,572 @@
+package scheduling
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+// simulatedSelfUrl identifies the simulated node
+const simulatedSelfUrl = "http://simulated-node"
+
+// simulationIdleHorizon is how long the simulation goes on after the last
+// arrival while nothing is running and no request is served or dropped: the
+// periodic events (e.g., the janitor) would keep it going forever otherwise,
+// if some requests never get a decision
+const simulationIdleHorizon = time.Hour
+
+// SimulationModel describes the simulated node, the functions it serves and
+// the nodes it can offload to.
+type SimulationModel struct {
+	CPUs       float64
+	MemoryMB   int64
+	Functions  []SimulatedFunction
+	Neighbours []SimulatedNode // nearby Edge nodes
+	Cloud      *SimulatedNode
+	Seed       int64
+}
+
+// SimulatedFunction models the behaviour of a function. Execution times are
+// exponentially distributed.
+type SimulatedFunction struct {
+	Name      string
+	MemoryMB  int64
+	CPUDemand float64
+	Duration  float64 // mean execution time (s)
+	ColdStart float64 // container initialization time (s)
+
+	MaxConcurrency             int64
+	ReservedCPUs               float64
+	ReservedMemMB              int64
+	MaxConcurrencyPerContainer int64
+	KeepAlive                  float64
+	MinWarm                    int64
+}
+
+// SimulatedNode models a remote node. Zero CPUs or memory mean unlimited
+// resources (e.g., for the Cloud).
+type SimulatedNode struct {
+	Url      string
+	CPUs     float64
+	MemoryMB int64
+	RTT      float64 // round-trip time (s)
+}
+
+// TraceEntry is a request in a simulation trace.
+type TraceEntry struct {
+	Time            float64 // arrival time (s) since the beginning of the trace
+	Function        string
+	Class           string // low, performance or availability
+	MaxRespT        float64
+	CanDoOffloading bool
+}
+
+// ClassResults contains the statistics collected for a service class.
+type ClassResults struct {
+	Requests       int
+	Completed      int
+	Dropped        int
+	Offloaded      int
+	ColdStarts     int
+	DeadlineMisses int
+	MeanRespTime   float64
+	P95RespTime    float64
+}
+
+// SimulationResults contains the statistics collected for each service class.
+type SimulationResults struct {
+	SimulatedTime float64 // (s)
+	Unserved      int     // requests neither completed nor dropped
+	Classes       map[string]*ClassResults
+}
+
+var simClasses = map[string]function.ServiceClass{
+	"low":          function.LOW,
+	"performance":  function.HIGH_PERFORMANCE,
+	"availability": function.HIGH_AVAILABILITY,
+}
+
+// ReadTrace parses a trace, with a JSON-encoded TraceEntry per line.
+func ReadTrace(reader io.Reader) ([]TraceEntry, error) {
+	trace := make([]TraceEntry, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid trace entry %d: %v", len(trace)+1, err)
+		}
+		trace = append(trace, entry)
+	}
+	return trace, scanner.Err()
+}
+
+// simEvent is something happening at a given (simulated) time.
+type simEvent struct {
+	t   time.Time
+	seq uint64
+	f   func()
+}
+
+type eventHeap []simEvent
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if !h[i].t.Equal(h[j].t) {
+		return h[i].t.Before(h[j].t)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x any) {
+	*h = append(*h, x.(simEvent))
+}
+
+func (h *eventHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = simEvent{}
+	*h = old[:n-1]
+	return e
+}
+
+// simClock is a clock.Clock whose time only advances when the next event is
+// processed.
+type simClock struct {
+	sync.Mutex
+	now    time.Time
+	events eventHeap
+	seq    uint64
+}
+
+func (c *simClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *simClock) AfterFunc(d time.Duration, f func()) {
+	c.Lock()
+	defer c.Unlock()
+	heap.Push(&c.events, simEvent{t: c.now.Add(d), seq: c.seq, f: f})
+	c.seq++
+}
+
+// next removes the earliest event, moving the time forward.
+func (c *simClock) next() (func(), bool) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.events) == 0 {
+		return nil, false
+	}
+	e := heap.Pop(&c.events).(simEvent)
+	c.now = e.t
+	return e.f, true
+}
+
+// simFunction is a simulated function, along with its definition.
+type simFunction struct {
+	*SimulatedFunction
+	fun *function.Function
+}
+
+// remoteNode is the state of a simulated remote node.
+type remoteNode struct {
+	SimulatedNode
+	usedCPUs  float64
+	usedMemMB int64
+	warm      map[string]int // idle containers for each function
+}
+
+type simulator struct {
+	clock     *simClock
+	policy    Policy
+	rng       *rand.Rand
+	functions map[string]*simFunction
+	remotes   map[string]*remoteNode
+	waiting   []*scheduledRequest // requests waiting for a decision
+	pending   int                 // requests not completed or dropped yet
+	arrivals  int                 // requests of the trace yet to arrive
+	running   int                 // requests being executed (locally or remotely)
+	progress  time.Time           // last arrival, completion or drop
+	results   *SimulationResults
+	respTimes map[string][]float64
+}
+
+// Simulate runs a policy against a trace, in simulated time, and returns the
+// collected statistics. Containers are not actually created and remote nodes
+// are simulated as well, according to the model. Requests still waiting for
+// a decision simulationIdleHorizon after the last arrival (while nothing else
+// happens) are reported as Unserved.
+// Simulate takes over the node state (i.e., the available resources, the
+// container pools and the clock), so it cannot be used on a running node.
+func Simulate(p Policy, model *SimulationModel, trace []TraceEntry) (*SimulationResults, error) {
+	s := &simulator{
+		clock:     &simClock{now: time.Unix(0, 0)},
+		policy:    p,
+		rng:       rand.New(rand.NewSource(model.Seed)),
+		functions: make(map[string]*simFunction),
+		remotes:   make(map[string]*remoteNode),
+		results:   &SimulationResults{Classes: make(map[string]*ClassResults)},
+		respTimes: make(map[string][]float64),
+	}
+	for i := range model.Functions {
+		f := &model.Functions[i]
+		s.functions[f.Name] = &simFunction{SimulatedFunction: f, fun: &function.Function{
+			Name:                       f.Name,
+			Runtime:                    container.CUSTOM_RUNTIME,
+			MemoryMB:                   f.MemoryMB,
+			CPUDemand:                  f.CPUDemand,
+			MaxConcurrency:             f.MaxConcurrency,
+			ReservedCPUs:               f.ReservedCPUs,
+			ReservedMemMB:              f.ReservedMemMB,
+			MaxConcurrencyPerContainer: f.MaxConcurrencyPerContainer,
+			KeepAlive:                  f.KeepAlive,
+			MinWarm:                    f.MinWarm,
+		}}
+	}
+	for _, entry := range trace {
+		if _, ok := s.functions[entry.Function]; !ok {
+			return nil, fmt.Errorf("unknown function in trace: %s", entry.Function)
+		}
+		if _, ok := simClasses[entry.Class]; !ok && entry.Class != "" {
+			return nil, fmt.Errorf("unknown service class in trace: %s", entry.Class)
+		}
+	}
+
+	clock.Set(s.clock)
+	container.InitSimulatedContainerFactory()
+	node.InitResources(model.CPUs, model.MemoryMB)
+	for _, f := range s.functions {
+		node.RegisterFunction(f.fun)
+	}
+
+	remoteServerUrl = ""
+	if model.Cloud != nil {
+		remoteServerUrl = model.Cloud.Url
+		s.remotes[model.Cloud.Url] = &remoteNode{SimulatedNode: *model.Cloud, warm: make(map[string]int)}
+	}
+	registration.Reg = &registration.Registry{NearbyServersMap: make(map[string]*registration.StatusInformation)}
+	for _, n := range model.Neighbours {
+		s.remotes[n.Url] = &remoteNode{SimulatedNode: n, warm: make(map[string]int)}
+		s.publishStatus(s.remotes[n.Url])
+	}
+	selfUrl = simulatedSelfUrl
+	maxOffloadAttempts = config.GetInt(config.SCHEDULER_OFFLOAD_ATTEMPTS, 3)
+
+	initDecisionLog()
+	initWarmRouting()
+	nodeDistance = func(status *registration.StatusInformation) (time.Duration, bool) {
+		if n, ok := s.remotes[status.Url]; ok {
+			return seconds(n.RTT), true
+		}
+		return 0, false
+	}
+	p.Init()
+	setCurrentPolicy(p)
+
+	cleanupPeriod := time.Duration(config.GetInt(config.POOL_CLEANUP_PERIOD, 30)) * time.Second
+	var janitor func()
+	janitor = func() {
+		node.CheckWarmContainers()
+		node.DeleteExpiredContainer()
+		s.clock.AfterFunc(cleanupPeriod, janitor)
+	}
+	s.clock.AfterFunc(cleanupPeriod, janitor)
+
+	if node.InitAutoscaler() {
+		var autoscaler func()
+		autoscaler = func() {
+			node.Autoscale()
+			s.clock.AfterFunc(node.AutoscalerInterval(), autoscaler)
+		}
+		s.clock.AfterFunc(node.AutoscalerInterval(), autoscaler)
+	}
+
+	for i := range trace {
+		entry := trace[i]
+		reqId := fmt.Sprintf("%s-%d", entry.Function, i)
+		s.clock.AfterFunc(seconds(entry.Time), func() { s.arrive(reqId, &entry) })
+	}
+	s.pending = len(trace)
+	s.arrivals = len(trace)
+
+	for s.pending > 0 {
+		f, ok := s.clock.next()
+		if !ok || s.idle() {
+			break
+		}
+		f()
+		s.pollDecisions()
+	}
+	s.results.Unserved = s.pending
+	s.results.SimulatedTime = s.clock.Now().Sub(time.Unix(0, 0)).Seconds()
+	for class, stats := range s.results.Classes {
+		stats.MeanRespTime, stats.P95RespTime = meanAndP95(s.respTimes[class])
+	}
+	return s.results, nil
+}
+
+// idle checks whether only the periodic events are left, and they have not
+// served or dropped any request for simulationIdleHorizon.
+func (s *simulator) idle() bool {
+	return s.arrivals == 0 && s.running == 0 && s.clock.Now().Sub(s.progress) > simulationIdleHorizon
+}
+
+func (s *simulator) classResults(r *scheduledRequest) (string, *ClassResults) {
+	class := "low"
+	for name, c := range simClasses {
+		if c == r.Class {
+			class = name
+		}
+	}
+	stats, ok := s.results.Classes[class]
+	if !ok {
+		stats = &ClassResults{}
+		s.results.Classes[class] = stats
+	}
+	return class, stats
+}
+
+func (s *simulator) arrive(reqId string, entry *TraceEntry) {
+	f := s.functions[entry.Function]
+	r := &scheduledRequest{
+		Request: &function.Request{
+			ReqId:           reqId,
+			Fun:             f.fun,
+			Arrival:         s.clock.Now(),
+			RequestQoS:      function.RequestQoS{Class: simClasses[entry.Class], MaxRespT: entry.MaxRespT},
+			CanDoOffloading: entry.CanDoOffloading,
+			Offloading:      function.OffloadingInfo{MaxHops: config.GetInt(config.SCHEDULER_OFFLOAD_MAX_HOPS, 2)},
+		},
+		ctx:             context.Background(),
+		decisionChannel: make(chan schedDecision, 1),
+	}
+	_, stats := s.classResults(r)
+	stats.Requests++
+	s.arrivals--
+	s.progress = s.clock.Now()
+
+	s.waiting = append(s.waiting, r)
+	s.policy.OnArrival(r)
+}
+
+// pollDecisions carries out the decisions taken so far by the policy.
+func (s *simulator) pollDecisions() {
+	stillWaiting := s.waiting[:0]
+	for _, r := range s.waiting {
+		select {
+		case d := <-r.decisionChannel:
+			s.carryOut(r, d)
+		default:
+			stillWaiting = append(stillWaiting, r)
+		}
+	}
+	for i := len(stillWaiting); i < len(s.waiting); i++ {
+		s.waiting[i] = nil
+	}
+	s.waiting = stillWaiting
+}
+
+func (s *simulator) carryOut(r *scheduledRequest, d schedDecision) {
+	switch d.action {
+	case DROP, DROP_EXPIRED:
+		s.drop(r)
+	case EXEC_REMOTE:
+		err := offloadWithFallback(r, d.remoteHost, func(_ context.Context, _ *function.Request, serverUrl string) error {
+			return s.offload(r, serverUrl)
+		})
+		if err != nil {
+			r.failed = true
+			notifyRemoteCompletion(s.policy, r)
+			s.drop(r)
+		}
+	default:
+		s.execute(r, d.contID)
+	}
+}
+
+func (s *simulator) drop(r *scheduledRequest) {
+	_, stats := s.classResults(r)
+	stats.Dropped++
+	s.pending--
+	s.progress = s.clock.Now()
+}
+
+// execute runs a request on a local container.
+func (s *simulator) execute(r *scheduledRequest, contID container.ContainerID) {
+	f := s.functions[r.Fun.Name]
+	initTime := 0.0
+	if !r.ExecReport.IsWarmStart {
+		initTime = f.ColdStart
+	}
+	duration := s.rng.ExpFloat64() * f.Duration
+
+	s.running++
+	s.clock.AfterFunc(seconds(initTime+duration), func() {
+		r.ExecReport.InitTime += initTime
+		r.ExecReport.Duration = duration
+		r.ExecReport.ResponseTime = s.clock.Now().Sub(r.Arrival).Seconds()
+
+		node.ReleaseContainer(contID, r.Fun)
+		s.policy.OnCompletion(r)
+		observeExecution(r)
+		s.complete(r)
+	})
+}
+
+// offload runs a request on a simulated remote node, if it has enough
+// resources.
+func (s *simulator) offload(r *scheduledRequest, serverUrl string) error {
+	n, ok := s.remotes[serverUrl]
+	if !ok {
+		return &url.Error{Op: "Post", URL: serverUrl, Err: errors.New("unknown node")}
+	}
+	if n.CPUs > 0.0 && n.usedCPUs+r.Fun.CPUDemand > n.CPUs {
+		return node.OutOfResourcesErr
+	}
+
+	f := s.functions[r.Fun.Name]
+	warmStart := n.warm[f.Name] > 0
+	if warmStart {
+		n.warm[f.Name]--
+	} else if !s.reserveMemory(n, f) {
+		return node.OutOfResourcesErr
+	}
+	n.usedCPUs += r.Fun.CPUDemand
+	s.publishStatus(n)
+
+	initTime := 0.0
+	if !warmStart {
+		initTime = f.ColdStart
+	}
+	duration := s.rng.ExpFloat64() * f.Duration
+
+	s.running++
+	s.clock.AfterFunc(seconds(n.RTT+initTime+duration), func() {
+		n.usedCPUs -= r.Fun.CPUDemand
+		n.warm[f.Name]++
+		s.publishStatus(n)
+
+		r.ExecReport.IsWarmStart = warmStart
+		r.ExecReport.InitTime = initTime
+		r.ExecReport.Duration = duration
+		r.ExecReport.OffloadLatency = n.RTT
+		r.ExecReport.ResponseTime = s.clock.Now().Sub(r.Arrival).Seconds()
+		r.ExecReport.SchedAction = SCHED_ACTION_OFFLOAD
+
+		notifyRemoteCompletion(s.policy, r)
+		s.complete(r)
+	})
+	return nil
+}
+
+// reserveMemory reserves memory for a new container on a remote node,
+// dismissing idle containers of other functions if needed.
+func (s *simulator) reserveMemory(n *remoteNode, f *simFunction) bool {
+	if n.MemoryMB <= 0 {
+		return true
+	}
+	for n.usedMemMB+f.MemoryMB > n.MemoryMB {
+		// pick the victim deterministically
+		victim := ""
+		for name, count := range n.warm {
+			if count > 0 && (victim == "" || name < victim) {
+				victim = name
+			}
+		}
+		if victim == "" {
+			return false
+		}
+		n.warm[victim]--
+		n.usedMemMB -= s.functions[victim].MemoryMB
+	}
+	n.usedMemMB += f.MemoryMB
+	return true
+}
+
+func (s *simulator) publishStatus(n *remoteNode) {
+	if n.Url == remoteServerUrl {
+		// the Cloud is not a nearby node
+		return
+	}
+
+	warm := make(map[string]int)
+	for name, count := range n.warm {
+		warm[name] = count
+	}
+	status := &registration.StatusInformation{
+		Url:                     n.Url,
+		AvailableWarmContainers: warm,
+		AvailableMemMB:          math.MaxInt64,
+		AvailableCPUs:           math.MaxFloat64,
+	}
+	if n.MemoryMB > 0 {
+		status.AvailableMemMB = n.MemoryMB - n.usedMemMB
+	}
+	if n.CPUs > 0.0 {
+		status.AvailableCPUs = n.CPUs - n.usedCPUs
+	}
+	registration.Reg.NearbyServersMap[n.Url] = status
+}
+
+func (s *simulator) complete(r *scheduledRequest) {
+	class, stats := s.classResults(r)
+	report := &r.ExecReport
+	stats.Completed++
+	if report.SchedAction == SCHED_ACTION_OFFLOAD {
+		stats.Offloaded++
+	}
+	if !report.IsWarmStart {
+		stats.ColdStarts++
+	}
+	if r.MaxRespT > 0.0 && report.ResponseTime > r.MaxRespT {
+		stats.DeadlineMisses++
+	}
+	s.respTimes[class] = append(s.respTimes[class], report.ResponseTime)
+	s.pending--
+	s.running--
+	s.progress = s.clock.Now()
+}
+
+func seconds(t float64) time.Duration {
+	return time.Duration(t * float64(time.Second))
+}
+
+func meanAndP95(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0.0, 0.0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	p95 := sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	return sum / float64(len(sorted)), p95
+}
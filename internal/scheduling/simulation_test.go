@@ -0,0 +1,163 @@
+package scheduling
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/clock"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+// newTestTrace returns n requests for each function, one every interval
+// seconds, alternating the service classes.
+func newTestTrace(functions []string, n int, interval float64, canDoOffloading bool) []TraceEntry {
+	classes := []string{"low", "performance", "availability"}
+	trace := make([]TraceEntry, 0, n*len(functions))
+	for i := 0; i < n; i++ {
+		for _, f := range functions {
+			trace = append(trace, TraceEntry{
+				Time:            float64(i) * interval,
+				Function:        f,
+				Class:           classes[i%len(classes)],
+				CanDoOffloading: canDoOffloading,
+			})
+		}
+	}
+	return trace
+}
+
+func simulate(t *testing.T, p Policy, model *SimulationModel, trace []TraceEntry) *SimulationResults {
+	oldReg, oldRemote := registration.Reg, remoteServerUrl
+	t.Cleanup(func() {
+		clock.Set(clock.Real())
+		registration.Reg, remoteServerUrl = oldReg, oldRemote
+	})
+
+	results, err := Simulate(p, model, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+// total sums the results of all the service classes.
+func total(results *SimulationResults) ClassResults {
+	var sum ClassResults
+	for _, c := range results.Classes {
+		sum.Requests += c.Requests
+		sum.Completed += c.Completed
+		sum.Dropped += c.Dropped
+		sum.Offloaded += c.Offloaded
+	}
+	return sum
+}
+
+func TestSimulationCounts(t *testing.T) {
+	fib := SimulatedFunction{Name: "fib", MemoryMB: 128, CPUDemand: 1, Duration: 0.05, ColdStart: 0.1}
+	cloud := &SimulatedNode{Url: "http://cloud", RTT: 0.1}
+
+	tests := []struct {
+		name            string
+		policy          string
+		model           SimulationModel
+		canDoOffloading bool
+		completed       int
+		dropped         int
+		offloaded       int
+	}{
+		{"served locally", "default",
+			SimulationModel{CPUs: 4, MemoryMB: 1024, Functions: []SimulatedFunction{fib}, Cloud: cloud, Seed: 1},
+			false, 60, 0, 0},
+		{"no resources", "default",
+			SimulationModel{CPUs: 4, MemoryMB: 64, Functions: []SimulatedFunction{fib}, Cloud: cloud, Seed: 1},
+			false, 0, 60, 0},
+		{"offloaded to the Cloud", "cloudonly",
+			SimulationModel{CPUs: 4, MemoryMB: 1024, Functions: []SimulatedFunction{fib}, Cloud: cloud, Seed: 1},
+			true, 60, 0, 60},
+		{"no Cloud to offload to", "cloudonly",
+			SimulationModel{CPUs: 4, MemoryMB: 1024, Functions: []SimulatedFunction{fib}, Seed: 1},
+			true, 0, 60, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := newTestTrace([]string{"fib"}, 60, 1.0, tt.canDoOffloading)
+			results := simulate(t, NewPolicy(tt.policy), &tt.model, trace)
+			got := total(results)
+			if results.Unserved != 0 {
+				t.Errorf("%d requests unserved", results.Unserved)
+			}
+			if got.Requests != len(trace) || got.Completed != tt.completed || got.Dropped != tt.dropped || got.Offloaded != tt.offloaded {
+				t.Errorf("got %d requests, %d completed, %d dropped, %d offloaded; want %d, %d, %d, %d",
+					got.Requests, got.Completed, got.Dropped, got.Offloaded,
+					len(trace), tt.completed, tt.dropped, tt.offloaded)
+			}
+		})
+	}
+}
+
+func TestSimulationReproducible(t *testing.T) {
+	model := &SimulationModel{
+		CPUs: 2, MemoryMB: 512, Seed: 7,
+		Functions: []SimulatedFunction{
+			{Name: "fib", MemoryMB: 128, CPUDemand: 1, Duration: 0.3, ColdStart: 0.5},
+			{Name: "img", MemoryMB: 256, CPUDemand: 1, Duration: 0.5, ColdStart: 1.0},
+			{Name: "hello", MemoryMB: 128, CPUDemand: 0.5, Duration: 0.1, ColdStart: 0.2},
+		},
+		Neighbours: []SimulatedNode{
+			{Url: "http://edge1", CPUs: 2, MemoryMB: 512, RTT: 0.02},
+			{Url: "http://edge2", CPUs: 2, MemoryMB: 512, RTT: 0.02},
+			{Url: "http://edge3", CPUs: 1, MemoryMB: 256, RTT: 0.03},
+		},
+		Cloud: &SimulatedNode{Url: "http://cloud", RTT: 0.1},
+	}
+	trace := newTestTrace([]string{"fib", "img", "hello"}, 200, 0.1, true)
+
+	// each policy runs after the others as well, which must leave nothing
+	// behind (e.g., containers destroyed in the background)
+	policies := []string{"default", "edgecloud", "edgeonly", "qosaware", "learning"}
+	first := make(map[string]*SimulationResults)
+	for i := 0; i < 3; i++ {
+		for _, policy := range policies {
+			results := simulate(t, NewPolicy(policy), model, trace)
+			if i == 0 {
+				first[policy] = results
+			} else if !reflect.DeepEqual(first[policy], results) {
+				t.Errorf("%s: run %d differs: %+v vs %+v", policy, i+1, total(first[policy]), total(results))
+			}
+		}
+	}
+}
+
+// queuedPolicy is the default policy with a queue, whatever the configuration.
+type queuedPolicy struct {
+	DefaultLocalPolicy
+}
+
+func (p *queuedPolicy) Init() {
+	p.DefaultLocalPolicy.Init()
+	p.queue = NewFIFOQueue(100)
+	p.durations = make(map[string]float64)
+	clock.AfterFunc(queueSweepInterval, p.sweepQueue)
+}
+
+func TestSimulationUnserved(t *testing.T) {
+	// the memory is reserved for another function, and queued requests wait
+	// with no limit: they are never served (nor dropped)
+	model := &SimulationModel{
+		CPUs: 2, MemoryMB: 256, Seed: 1,
+		Functions: []SimulatedFunction{
+			{Name: "fib", MemoryMB: 128, CPUDemand: 1, Duration: 0.1, ColdStart: 0.1},
+			{Name: "reserved", MemoryMB: 128, CPUDemand: 1, Duration: 0.1, ColdStart: 0.1, ReservedMemMB: 256},
+		},
+	}
+	trace := newTestTrace([]string{"fib"}, 10, 1.0, false)
+
+	results := simulate(t, &queuedPolicy{}, model, trace)
+	if results.Unserved != len(trace) {
+		t.Errorf("%d requests unserved, want %d", results.Unserved, len(trace))
+	}
+	if got := total(results); got.Completed != 0 || got.Dropped != 0 {
+		t.Errorf("got %d completed, %d dropped", got.Completed, got.Dropped)
+	}
+}
@@ -1,6 +1,9 @@
 package scheduling
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/grussorusso/serverledge/internal/container"
 	"github.com/grussorusso/serverledge/internal/function"
 )
@@ -8,13 +11,28 @@ import (
 // scheduledRequest represents a Request within the scheduling subsystem
 type scheduledRequest struct {
 	*function.Request
+	ctx             context.Context // done when the request is abandoned
 	decisionChannel chan schedDecision
 	priority        float64
+	remoteHost      string   // set if the request has been offloaded
+	reasons         []string // why the request could not be served otherwise
+	failed          bool     // the request could not be served (e.g., offloading failed)
 }
 
 type completion struct {
 	*scheduledRequest
-	contID container.ContainerID
+	contID           container.ContainerID
+	discardContainer bool // the container must be destroyed rather than reused
+}
+
+// newCompletion notifies the end of a request. The completion is handled
+// concurrently, while the request may be recycled (e.g., by the API) as soon
+// as it has been served: the completion holds a copy of the request instead.
+func newCompletion(r *scheduledRequest, contID container.ContainerID, discardContainer bool) *completion {
+	req := *r.Request
+	copied := *r
+	copied.Request = &req
+	return &completion{scheduledRequest: &copied, contID: contID, discardContainer: discardContainer}
 }
 
 // schedDecision wraps a action made by the scheduler.
@@ -33,6 +51,8 @@ const (
 	EXEC_LOCAL                   = 1
 	EXEC_REMOTE                  = 2
 	BEST_EFFORT_EXECUTION        = 3
+	DROP_EXPIRED                 = 4
+	DROP_DRAINING                = 5
 )
 
 type schedulingDecision int64
@@ -43,3 +63,15 @@ const (
 	SCHED_LOCAL                     = 2
 	SCHED_BASIC                     = 3
 )
+
+// note records why the request could not be served in some way (e.g., there
+// is no warm container), to explain the scheduling decision.
+func (r *scheduledRequest) note(format string, args ...any) {
+	r.reasons = append(r.reasons, fmt.Sprintf(format, args...))
+}
+
+// abandoned returns true if the request is no longer worth serving, e.g.,
+// the client has gone away.
+func (r *scheduledRequest) abandoned() bool {
+	return r.ctx != nil && r.ctx.Err() != nil
+}
@@ -0,0 +1,22 @@
+package scheduling
+
+import (
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+func TestCompletionOutlivesRequest(t *testing.T) {
+	fib := &function.Function{Name: "fib"}
+	r := &scheduledRequest{Request: &function.Request{Fun: fib, ExecReport: function.ExecutionReport{Duration: 1.0}}}
+	c := newCompletion(r, "cont", false)
+
+	// the request is recycled for another function (e.g., by the API)
+	r.Fun = &function.Function{Name: "hello"}
+	r.ExecReport = function.ExecutionReport{}
+	r.failed = true
+
+	if c.Fun != fib || c.ExecReport.Duration != 1.0 || c.failed {
+		t.Errorf("completion changed with the request: %s, %+v, failed %v", c.Fun, c.ExecReport, c.failed)
+	}
+}
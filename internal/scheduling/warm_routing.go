@@ -0,0 +1,127 @@
+package scheduling
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+// warmRouting is whether requests with no local warm container are forwarded
+// to nearby nodes having one, when faster than a local cold start
+var warmRouting bool
+
+// nodeDistance estimates the RTT to a nearby node (it is replaced by the
+// simulator)
+var nodeDistance = vivaldiDistance
+
+// localEstimates is the estimated cold start and execution time of the
+// functions executed locally
+var localEstimates = struct {
+	sync.Mutex
+	m map[string]*executionEstimate
+}{}
+
+type executionEstimate struct {
+	coldInitTime float64
+	duration     float64
+}
+
+// initWarmRouting configures warm-aware routing, with no estimates.
+func initWarmRouting() {
+	warmRouting = config.GetBool(config.SCHEDULER_WARM_ROUTING, false)
+
+	localEstimates.Lock()
+	localEstimates.m = make(map[string]*executionEstimate)
+	localEstimates.Unlock()
+}
+
+// vivaldiDistance returns the RTT to a nearby node, as estimated through the
+// Vivaldi coordinates of the nodes.
+func vivaldiDistance(status *registration.StatusInformation) (time.Duration, bool) {
+	if registration.Reg == nil || registration.Reg.Client == nil {
+		return 0, false
+	}
+	return registration.Reg.Client.DistanceTo(&status.Coordinates), true
+}
+
+// observeExecution updates the estimates with a completed request, if it has
+// been executed locally. The node also keeps its own estimates, to evict
+// containers and autoscale.
+func observeExecution(r *scheduledRequest) {
+	report := &r.ExecReport
+	if report.ResponseTime <= 0.0 || report.SchedAction == SCHED_ACTION_OFFLOAD {
+		return
+	}
+	node.ObserveExecution(r.Fun, report)
+	if !warmRouting {
+		return
+	}
+
+	localEstimates.Lock()
+	defer localEstimates.Unlock()
+	e, ok := localEstimates.m[r.Fun.Name]
+	if !ok {
+		e = &executionEstimate{}
+		localEstimates.m[r.Fun.Name] = e
+	}
+	e.duration = ewma(e.duration, report.Duration, durationAlpha)
+	if !report.IsWarmStart {
+		e.coldInitTime = ewma(e.coldInitTime, report.InitTime, durationAlpha)
+	}
+}
+
+// tryWarmRouting forwards a request to the closest nearby node with a warm
+// container for the function, if the RTT plus a warm execution is expected to
+// take less than a local cold start. It returns false if the request must be
+// served otherwise.
+func tryWarmRouting(r *scheduledRequest) bool {
+	if !warmRouting || !r.CanDoOffloading || registration.Reg == nil {
+		return false
+	}
+
+	localEstimates.Lock()
+	e, ok := localEstimates.m[r.Fun.Name]
+	var estimate executionEstimate
+	if ok {
+		estimate = *e
+	}
+	localEstimates.Unlock()
+	if estimate.coldInitTime <= 0.0 {
+		// no cold start observed yet
+		return false
+	}
+
+	targetUrl := ""
+	var targetRTT time.Duration
+	for _, v := range registration.Reg.NearbyServersMap {
+		if v.Url == selfUrl || r.Offloading.HasVisited(v.Url) {
+			continue
+		}
+		if v.AvailableWarmContainers[r.Fun.Name] < 1 || v.AvailableCPUs < r.Fun.CPUDemand {
+			continue
+		}
+		rtt, ok := nodeDistance(v)
+		// ties are broken by URL, so that simulations are reproducible
+		if ok && (targetUrl == "" || rtt < targetRTT || (rtt == targetRTT && v.Url < targetUrl)) {
+			targetUrl = v.Url
+			targetRTT = rtt
+		}
+	}
+	if targetUrl == "" {
+		return false
+	}
+
+	remoteEstimate := targetRTT.Seconds() + estimate.duration
+	localEstimate := estimate.coldInitTime + estimate.duration
+	if remoteEstimate >= localEstimate {
+		r.note("warm container at %s: %.3f s expected, vs %.3f s for a local cold start", targetUrl, remoteEstimate, localEstimate)
+		return false
+	}
+
+	r.note("local cold start: %.3f s expected, vs %.3f s with the warm container at %s", localEstimate, remoteEstimate, targetUrl)
+	handleOffload(r, targetUrl)
+	return true
+}